@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ruleWarning is a problem of the rule found by analyzeRules.
+type ruleWarning struct {
+	// Rule is the index of the rule that has the problem.
+	Rule    int
+	Message string
+}
+
+// analyzeRules finds the rules that can never be used.
+func analyzeRules(s *setting) []ruleWarning {
+	var r []ruleWarning
+	for i := range s.Rule {
+		b := &s.Rule[i]
+		for j := 0; j < i; j++ {
+			if a := &s.Rule[j]; !a.Continue && a.covers(b) {
+				r = append(r, ruleWarning{
+					Rule:    b.index,
+					Message: fmt.Sprintf("ルール %d が同じファイルに必ず先に一致するため、ルール %d は使用されません", a.index, b.index),
+				})
+				break
+			}
+		}
+		for j := 0; j < i; j++ {
+			if a := &s.Rule[j]; a.Sort != b.Sort && a.containsDir(b.ExpandedDir(), false) {
+				r = append(r, ruleWarning{
+					Rule:    b.index,
+					Message: fmt.Sprintf("同じフォルダーを監視するルール %d の sort が優先されるため、ルール %d の sort は使用されません", a.index, b.index),
+				})
+				break
+			}
+		}
+		if asas := b.unreachableAsas(s.Asas); len(asas) > 0 {
+			r = append(r, ruleWarning{
+				Rule:    b.index,
+				Message: fmt.Sprintf("ルール %d の対象ファイル名は、同じフォルダーに保存する [[asas]] のフォーマット %s に一致しません", b.index, strings.Join(asas, ", ")),
+			})
+		}
+	}
+	return r
+}
+
+// hasCondition reports whether the rule has any condition other than the folder and the filename.
+func (r *rule) hasCondition() bool {
+	return r.textRE != nil || r.projectRE != nil || r.hasAudioCondition()
+}
+
+// covers reports whether the rule always matches before the rule b matches.
+// It only reports the cases that can be proved, so it may return false even if b is never used.
+func (r *rule) covers(b *rule) bool {
+	if r.hasCondition() || !r.containsDir(b.ExpandedDir(), b.Recursive) {
+		return false
+	}
+	for _, ext := range b.AudioExt {
+		if !r.AcceptsAudio(ext) {
+			return false
+		}
+	}
+	if r.TextFrom == "file" {
+		if b.TextFrom != "file" {
+			return false
+		}
+		for _, ext := range b.TextExt {
+			if !containsExt(r.TextExt, ext) {
+				return false
+			}
+		}
+	}
+	switch {
+	case r.FileRE == "" && b.FileRE == "":
+		return wildcardCovers(r.File, b.File)
+	case r.FileRE == "" && b.FileRE != "":
+		return wildcardCovers(r.File, "*")
+	default:
+		return r.FileRE == b.FileRE
+	}
+}
+
+// unreachableAsas returns the formats of [[asas]] that save files to the rule's folder
+// if the rule cannot match any of them.
+func (r *rule) unreachableAsas(asas []asas) []string {
+	var formats []string
+	for i := range asas {
+		a := &asas[i]
+		if !a.Exists() || !r.containsDir(a.ExpandedFolder(), false) {
+			continue
+		}
+		if r.FileRE == "" {
+			if wildcardIntersects(r.File, a.Format) {
+				return nil
+			}
+		} else if r.fileRE.MatchString(strings.ReplaceAll(a.Format, "*", "20201231235959")) {
+			// asas replaces * with the timestamp.
+			return nil
+		}
+		formats = append(formats, a.Format)
+	}
+	return formats
+}
+
+// wildcardCovers reports whether every filename that matches the wildcard b also matches the wildcard a.
+func wildcardCovers(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	memo := map[[2]int]bool{}
+	var covers func(i, j int) bool
+	covers = func(i, j int) bool {
+		key := [2]int{i, j}
+		if v, ok := memo[key]; ok {
+			return v
+		}
+		var v bool
+		switch {
+		case j == len(br):
+			v = strings.Trim(string(ar[i:]), "*") == ""
+		case i == len(ar):
+			v = false
+		case ar[i] == '*':
+			v = covers(i+1, j) || covers(i, j+1)
+		case br[j] == '*':
+			v = false
+		case ar[i] == '?' || ar[i] == br[j] && br[j] != '?':
+			v = covers(i+1, j+1)
+		}
+		memo[key] = v
+		return v
+	}
+	return covers(0, 0)
+}
+
+// wildcardIntersects reports whether there is a filename that matches both wildcards.
+func wildcardIntersects(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	memo := map[[2]int]bool{}
+	var intersects func(i, j int) bool
+	intersects = func(i, j int) bool {
+		key := [2]int{i, j}
+		if v, ok := memo[key]; ok {
+			return v
+		}
+		var v bool
+		switch {
+		case i < len(ar) && ar[i] == '*':
+			v = intersects(i+1, j) || j < len(br) && intersects(i, j+1)
+		case j < len(br) && br[j] == '*':
+			v = intersects(i, j+1) || i < len(ar) && intersects(i+1, j)
+		case i == len(ar) || j == len(br):
+			v = i == len(ar) && j == len(br)
+		case ar[i] == '?' || br[j] == '?' || ar[i] == br[j]:
+			v = intersects(i+1, j+1)
+		}
+		memo[key] = v
+		return v
+	}
+	return intersects(0, 0)
+}
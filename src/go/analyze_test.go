@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWildcard(t *testing.T) {
+	tests := []struct {
+		A, B       string
+		Covers     bool
+		Intersects bool
+	}{
+		{"*", "voice_*.wav", true, true},
+		{"*.wav", "voice_*.wav", true, true},
+		{"voice_*.wav", "*.wav", false, true},
+		{"*_きりたん_*.wav", "??_きりたん_*.wav", true, true},
+		{"??_きりたん_*.wav", "*_きりたん_*.wav", false, true},
+		{"voice_?.wav", "voice_1.wav", true, true},
+		{"ボイロ2_*.wav", "きりたん_*.wav", false, false},
+		{"*_*.wav", "*.wav", false, true},
+		{"*.wav", "*.ogg", false, false},
+	}
+	for i, test := range tests {
+		if got := wildcardCovers(test.A, test.B); got != test.Covers {
+			t.Errorf("No.%d: covers want %v got %v", i, test.Covers, got)
+		}
+		if got := wildcardIntersects(test.A, test.B); got != test.Intersects {
+			t.Errorf("No.%d: intersects want %v got %v", i, test.Intersects, got)
+		}
+		if got := wildcardIntersects(test.B, test.A); got != test.Intersects {
+			t.Errorf("No.%d: reversed intersects want %v got %v", i, test.Intersects, got)
+		}
+	}
+}
+
+func TestAnalyzeRules(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "VoiceroidEditor.exe")
+	if err := os.WriteFile(exe, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Setting  string
+		Warnings []ruleWarning
+	}{
+		{
+			Setting: `
+[[rule]]
+file = '*.wav'
+layer = 1
+
+[[rule]]
+file = 'voice_*.wav'
+layer = 2
+`,
+			Warnings: []ruleWarning{{Rule: 2, Message: "ルール 1 が"}},
+		},
+		{
+			Setting: `
+[[rule]]
+file = 'cevio_*.wav'
+sort = 'name'
+
+[[rule]]
+file = 'voiceroid_*.wav'
+`,
+			Warnings: []ruleWarning{{Rule: 2, Message: "同じフォルダーを監視するルール 1 の sort"}},
+		},
+		{
+			Setting: `
+[[rule]]
+file = '*.wav'
+continue = true
+
+[[rule]]
+file = 'voice_*.wav'
+maxduration = 1000
+continue = true
+
+[[rule]]
+file = 'voice_*.wav'
+`,
+		},
+		{
+			Setting: `
+[[rule]]
+file = '*.wav'
+text = '^きりたん＞'
+
+[[rule]]
+file = 'voice_*.wav'
+
+[[rule]]
+filere = '^voice_\d+\.wav$'
+
+[[rule]]
+file = '*.wav'
+recursive = true
+
+[[rule]]
+filere = '^voice_\d+\.wav$'
+`,
+			Warnings: []ruleWarning{{Rule: 5, Message: "ルール 3 が"}},
+		},
+		{
+			Setting: `
+[[rule]]
+file = '*.wav'
+textfrom = 'filename'
+audioext = ['.wav', '.ogg']
+
+[[rule]]
+file = '*.wav'
+audioext = ['.wav', '.ogg']
+`,
+			Warnings: []ruleWarning{{Rule: 2, Message: "ルール 1 が"}},
+		},
+		{
+			Setting: `
+[[rule]]
+file = '*.wav'
+
+[[rule]]
+file = '*.wav'
+textfrom = 'filename'
+`,
+		},
+		{
+			Setting: `
+[[asas]]
+exe = '` + exe + `'
+format = 'ボイロ2_*.wav'
+
+[[asas]]
+exe = '` + filepath.Join(dir, "notfound.exe") + `'
+format = 'きりたん_*.wav'
+
+[[rule]]
+file = 'ボイロ2_*.wav'
+
+[[rule]]
+file = 'きりたん_*.wav'
+
+[[rule]]
+filere = '^ボイロ2_\d{14}\.wav$'
+
+[[rule]]
+filere = '^ボイロ2_[a-z]+\.wav$'
+`,
+			Warnings: []ruleWarning{
+				{Rule: 2, Message: "ルール 2 の対象ファイル名は"},
+				{Rule: 4, Message: "ルール 4 の対象ファイル名は"},
+			},
+		},
+	}
+	for i, test := range tests {
+		s, err := newSetting(strings.NewReader(test.Setting), dir, "")
+		if err != nil {
+			t.Fatalf("No.%d: %v", i, err)
+		}
+		if len(s.warnings) != len(test.Warnings) {
+			t.Errorf("No.%d: want %d warnings got %+v", i, len(test.Warnings), s.warnings)
+			continue
+		}
+		for j, w := range s.warnings {
+			if w.Rule != test.Warnings[j].Rule || !strings.HasPrefix(w.Message, test.Warnings[j].Message) {
+				t.Errorf("No.%d-%d: want %+v got %+v", i, j, test.Warnings[j], w)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+var errAsasUnsupported = errors.New("asas はこのプラットフォームでは使用できません")
+
+func (a *asas) UpdateRunning() (bool, error) {
+	return false, nil
+}
+
+func (a *asas) ConfirmAndRun(updateOnly bool) (bool, error) {
+	return false, nil
+}
+
+func (a *asas) Run() (bool, error) {
+	return false, errAsasUnsupported
+}
+
+func emulateAsas() error {
+	return errAsasUnsupported
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/oov/audio/wave"
+)
+
+// audioInfo is the format information of an audio file.
+// Bits is 0 for lossy formats.
+type audioInfo struct {
+	SampleRate int
+	Channels   int
+	Bits       int
+	Samples    int64
+}
+
+var errUnknownAudioFormat = errors.New("対応していない形式の音声ファイルです")
+
+func readAudioInfo(path string) (*audioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAudioInfoFrom(f)
+}
+
+func readAudioInfoFrom(r io.ReadSeeker) (*audioInfo, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var ai *audioInfo
+	var err error
+	switch {
+	case string(magic[:]) == "RIFF":
+		ai, err = readWaveInfo(r)
+	case string(magic[:]) == "fLaC":
+		ai, err = readFLACInfo(r)
+	case string(magic[:]) == "OggS":
+		ai, err = readOggInfo(r)
+	case string(magic[:3]) == "ID3" || (magic[0] == 0xff && magic[1]&0xe0 == 0xe0):
+		ai, err = readMP3Info(r)
+	default:
+		return nil, errUnknownAudioFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ai.Samples == 0 || ai.SampleRate == 0 || ai.Channels == 0 {
+		return nil, fmt.Errorf("音声ファイルに記録されている値が不正です")
+	}
+	return ai, nil
+}
+
+func readWaveInfo(r io.ReadSeeker) (*audioInfo, error) {
+	lr, wfe, err := wave.NewLimitedReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if wfe.Format.Channels == 0 || wfe.Format.BitsPerSample == 0 {
+		return nil, fmt.Errorf("waveファイルに記録されている値が不正です")
+	}
+	switch wfe.Format.FormatTag {
+	case wave.WAVE_FORMAT_PCM, wave.WAVE_FORMAT_IEEE_FLOAT, wave.WAVE_FORMAT_EXTENSIBLE:
+	default:
+		// the number of samples of compressed formats such as IMA ADPCM cannot be calculated from the size.
+		return nil, fmt.Errorf("圧縮された wave ファイルには対応していません")
+	}
+	if wfe.Format.BitsPerSample%8 != 0 {
+		return nil, fmt.Errorf("%d ビットの wave ファイルには対応していません", wfe.Format.BitsPerSample)
+	}
+	return &audioInfo{
+		SampleRate: int(wfe.Format.SamplesPerSec),
+		Channels:   int(wfe.Format.Channels),
+		Bits:       int(wfe.Format.BitsPerSample),
+		Samples:    lr.N / int64(wfe.Format.Channels) / int64(wfe.Format.BitsPerSample/8),
+	}, nil
+}
+
+func readFLACInfo(r io.ReadSeeker) (*audioInfo, error) {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("FLAC ファイルのメタデータが読み取れません: %w", err)
+		}
+		length := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		if hdr[0]&0x7f == 0 {
+			// STREAMINFO
+			var b [34]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, fmt.Errorf("FLAC ファイルのメタデータが読み取れません: %w", err)
+			}
+			return &audioInfo{
+				SampleRate: int(b[10])<<12 | int(b[11])<<4 | int(b[12])>>4,
+				Channels:   int(b[12]>>1&0x07) + 1,
+				Bits:       int(b[12]&0x01)<<4 | int(b[13]>>4) + 1,
+				Samples:    int64(b[13]&0x0f)<<32 | int64(b[14])<<24 | int64(b[15])<<16 | int64(b[16])<<8 | int64(b[17]),
+			}, nil
+		}
+		if hdr[0]&0x80 != 0 {
+			break
+		}
+		if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("FLAC ファイルに STREAMINFO がありません")
+}
+
+const oggPageHeaderSize = 27
+
+func readOggInfo(r io.ReadSeeker) (*audioInfo, error) {
+	var hdr [oggPageHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("Ogg ファイルが読み取れません: %w", err)
+	}
+	serial := binary.LittleEndian.Uint32(hdr[14:18])
+	segs := make([]byte, hdr[26])
+	if _, err := io.ReadFull(r, segs); err != nil {
+		return nil, fmt.Errorf("Ogg ファイルが読み取れません: %w", err)
+	}
+	var size int
+	for _, s := range segs {
+		size += int(s)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, fmt.Errorf("Ogg ファイルが読み取れません: %w", err)
+	}
+
+	var ai audioInfo
+	var preSkip int64
+	switch {
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		ai.Channels = int(packet[11])
+		ai.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		// Opus is always decoded at 48kHz.
+		ai.Channels = int(packet[9])
+		ai.SampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+	default:
+		return nil, errUnknownAudioFormat
+	}
+
+	// the granule position of the last page is the number of samples.
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	tailSize := int64(65536)
+	if tailSize > end {
+		tailSize = end
+	}
+	if _, err = r.Seek(end-tailSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, tailSize)
+	if _, err = io.ReadFull(r, tail); err != nil {
+		return nil, err
+	}
+	for pos := len(tail); ; {
+		pos = bytes.LastIndex(tail[:pos], []byte("OggS"))
+		if pos == -1 {
+			return nil, fmt.Errorf("Ogg ファイルの最終ページが見つかりません")
+		}
+		if pos+oggPageHeaderSize > len(tail) || binary.LittleEndian.Uint32(tail[pos+14:pos+18]) != serial {
+			continue
+		}
+		if tail[pos+5]&0x04 == 0 {
+			// the file is still being written.
+			return nil, fmt.Errorf("Ogg ファイルの終端が見つかりません")
+		}
+		ai.Samples = int64(binary.LittleEndian.Uint64(tail[pos+6:pos+14])) - preSkip
+		return &ai, nil
+	}
+}
+
+var (
+	mp3BitRates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG2, MPEG2.5
+	}
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG2.5
+		{0, 0, 0},             // reserved
+		{22050, 24000, 16000}, // MPEG2
+		{44100, 48000, 32000}, // MPEG1
+	}
+)
+
+func readMP3Info(r io.ReadSeeker) (*audioInfo, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var offset int64
+	var id3 [10]byte
+	if _, err = io.ReadFull(r, id3[:]); err != nil {
+		return nil, fmt.Errorf("MP3 ファイルが読み取れません: %w", err)
+	}
+	if string(id3[:3]) == "ID3" {
+		offset = 10 + (int64(id3[6]&0x7f)<<21 | int64(id3[7]&0x7f)<<14 | int64(id3[8]&0x7f)<<7 | int64(id3[9]&0x7f))
+		if id3[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+	if _, err = r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8192)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("MP3 ファイルが読み取れません: %w", err)
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := int(buf[i+1] >> 3 & 0x03)
+		layer := buf[i+1] >> 1 & 0x03
+		bitRateIndex := buf[i+2] >> 4
+		sampleRateIndex := buf[i+2] >> 2 & 0x03
+		if version == 1 || layer != 1 || bitRateIndex == 0 || bitRateIndex == 15 || sampleRateIndex == 3 {
+			// not a valid Layer III frame header.
+			continue
+		}
+		mpeg1 := version == 3
+		mono := buf[i+3]>>6 == 3
+		ai := audioInfo{
+			SampleRate: mp3SampleRates[version][sampleRateIndex],
+			Channels:   2,
+		}
+		if mono {
+			ai.Channels = 1
+		}
+		samplesPerFrame, sideInfo, bitRate := int64(1152), 32, mp3BitRates[0][bitRateIndex]
+		if mono {
+			sideInfo = 17
+		}
+		if !mpeg1 {
+			samplesPerFrame, sideInfo, bitRate = 576, 17, mp3BitRates[1][bitRateIndex]
+			if mono {
+				sideInfo = 9
+			}
+		}
+		// VBR files have the number of frames in the Xing / Info header.
+		if x := i + 4 + sideInfo; x+12 <= len(buf) {
+			if tag := string(buf[x : x+4]); (tag == "Xing" || tag == "Info") && buf[x+7]&0x01 != 0 {
+				ai.Samples = int64(binary.BigEndian.Uint32(buf[x+8:x+12])) * samplesPerFrame
+				return &ai, nil
+			}
+		}
+		// otherwise assume CBR.
+		size := end - offset - int64(i)
+		var tag [3]byte
+		if _, err = r.Seek(end-128, io.SeekStart); err == nil {
+			if _, err = io.ReadFull(r, tag[:]); err == nil && string(tag[:]) == "TAG" {
+				size -= 128
+			}
+		}
+		ai.Samples = size * 8 * int64(ai.SampleRate) / int64(bitRate*1000)
+		return &ai, nil
+	}
+	return nil, fmt.Errorf("MP3 ファイルのフレームが見つかりません")
+}
+
+// readWaveInfoText returns the title (INAM) or the comment (ICMT) in the LIST/INFO chunk of the wave file.
+func readWaveInfoText(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := readWaveInfoChunk(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []string{"INAM", "ICMT"} {
+		if v, ok := info[id]; ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("waveファイルに INAM / ICMT がありません")
+}
+
+// readWaveInfoChunk returns the subchunks in the LIST/INFO chunk.
+// Trailing NUL characters are removed.
+func readWaveInfoChunk(r io.Reader) (map[string][]byte, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("waveファイルではありません")
+	}
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("waveファイルに LIST/INFO チャンクがありません")
+			}
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		if string(chunk[0:4]) != "LIST" || size < 4 {
+			if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if string(b[0:4]) != "INFO" {
+			if size&1 == 1 {
+				if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		info := map[string][]byte{}
+		for b = b[4:]; len(b) >= 8; {
+			n := int(binary.LittleEndian.Uint32(b[4:8]))
+			if 8+n > len(b) {
+				break
+			}
+			info[string(b[0:4])] = bytes.TrimRight(b[8:8+n], "\x00")
+			next := 8 + n + n&1
+			if next > len(b) {
+				break
+			}
+			b = b[next:]
+		}
+		return info, nil
+	}
+}
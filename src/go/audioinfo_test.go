@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestOggPage(headerType byte, granule uint64, packet []byte) []byte {
+	b := make([]byte, oggPageHeaderSize, oggPageHeaderSize+1+len(packet))
+	copy(b, "OggS")
+	b[5] = headerType
+	binary.LittleEndian.PutUint64(b[6:], granule)
+	binary.LittleEndian.PutUint32(b[14:], 1)
+	if len(packet) > 0 {
+		b[26] = 1
+		b = append(b, byte(len(packet)))
+	}
+	return append(b, packet...)
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	flac := []byte("fLaC\x80\x00\x00\x22")
+	streamInfo := make([]byte, 34)
+	copy(streamInfo[10:], []byte{0x0a, 0xc4, 0x42, 0xf0, 0x00, 0x01, 0x58, 0x88})
+	flac = append(flac, streamInfo...)
+
+	vorbisHead := make([]byte, 30)
+	copy(vorbisHead, "\x01vorbis")
+	vorbisHead[11] = 1
+	binary.LittleEndian.PutUint32(vorbisHead[12:], 22050)
+	vorbis := append(makeTestOggPage(0x02, 0, vorbisHead), makeTestOggPage(0x04, 22050, nil)...)
+	vorbisWriting := append(makeTestOggPage(0x02, 0, vorbisHead), makeTestOggPage(0x00, 11025, nil)...)
+
+	opusHead := make([]byte, 19)
+	copy(opusHead, "OpusHead")
+	opusHead[8], opusHead[9] = 1, 2
+	binary.LittleEndian.PutUint16(opusHead[10:], 312)
+	binary.LittleEndian.PutUint32(opusHead[12:], 48000)
+	opus := append(makeTestOggPage(0x02, 0, opusHead), makeTestOggPage(0x04, 48312, nil)...)
+
+	// MPEG1 Layer III 128kbps 44.1kHz mono with Xing header after ID3v2 tag.
+	mp3VBR := []byte("ID3\x03\x00\x00\x00\x00\x00\x0a")
+	mp3VBR = append(mp3VBR, make([]byte, 10)...)
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0xc0})
+	copy(frame[4+17:], "Xing\x00\x00\x00\x01\x00\x00\x00\x64")
+	mp3VBR = append(mp3VBR, frame...)
+
+	// MPEG1 Layer III 128kbps 44.1kHz stereo without Xing header.
+	mp3CBR := make([]byte, 16000)
+	copy(mp3CBR, []byte{0xff, 0xfb, 0x90, 0x00})
+
+	// 4-bit IMA ADPCM 22.05kHz mono.
+	adpcm := make([]byte, 48+256)
+	copy(adpcm, "RIFF")
+	binary.LittleEndian.PutUint32(adpcm[4:], uint32(len(adpcm)-8))
+	copy(adpcm[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(adpcm[16:], 20)
+	binary.LittleEndian.PutUint16(adpcm[20:], 0x11)
+	binary.LittleEndian.PutUint16(adpcm[22:], 1)
+	binary.LittleEndian.PutUint32(adpcm[24:], 22050)
+	binary.LittleEndian.PutUint32(adpcm[28:], 11100)
+	binary.LittleEndian.PutUint16(adpcm[32:], 256)
+	binary.LittleEndian.PutUint16(adpcm[34:], 4)
+	binary.LittleEndian.PutUint16(adpcm[36:], 2)
+	binary.LittleEndian.PutUint16(adpcm[38:], 505)
+	copy(adpcm[40:], "data")
+	binary.LittleEndian.PutUint32(adpcm[44:], 256)
+
+	tests := []struct {
+		name string
+		data []byte
+		want *audioInfo
+	}{
+		{"flac", flac, &audioInfo{SampleRate: 44100, Channels: 2, Bits: 16, Samples: 88200}},
+		{"vorbis", vorbis, &audioInfo{SampleRate: 22050, Channels: 1, Samples: 22050}},
+		{"vorbis writing", vorbisWriting, nil},
+		{"opus", opus, &audioInfo{SampleRate: 48000, Channels: 2, Samples: 48000}},
+		{"mp3 vbr", mp3VBR, &audioInfo{SampleRate: 44100, Channels: 1, Samples: 115200}},
+		{"mp3 cbr", mp3CBR, &audioInfo{SampleRate: 44100, Channels: 2, Samples: 44100}},
+		{"wav 4bit adpcm", adpcm, nil},
+		{"unknown", []byte("unknown data"), nil},
+	}
+	for idx, data := range tests {
+		ai, err := readAudioInfoFrom(bytes.NewReader(data.data))
+		if data.want == nil {
+			if err == nil {
+				t.Errorf("No.%d %s: want error got %+v", idx, data.name, ai)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("No.%d %s: failed: %v", idx, data.name, err)
+			continue
+		}
+		if *ai != *data.want {
+			t.Errorf("No.%d %s: want %+v got %+v", idx, data.name, *data.want, *ai)
+		}
+	}
+
+	wavPath := filepath.Join(t.TempDir(), "test.wav")
+	writeTestWave(t, wavPath, 4800)
+	ai, err := readAudioInfo(wavPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (audioInfo{SampleRate: 48000, Channels: 1, Bits: 16, Samples: 4800}); *ai != want {
+		t.Errorf("wav: want %+v got %+v", want, *ai)
+	}
+}
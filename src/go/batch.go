@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// batchIdleCPU is the ratio of the CPU time to the elapsed time below which the process is considered idle.
+const batchIdleCPU = 0.05
+
+// batchIdleInterval is the minimum interval to measure the CPU usage of the process.
+const batchIdleInterval = time.Second
+
+type cpuSample struct {
+	CPU time.Duration
+	At  time.Time
+}
+
+// batchWaiter tells whether the TTS process has finished exporting a batch.
+type batchWaiter struct {
+	// cpuTime returns the CPU time used by the processes of the executable.
+	cpuTime func(exe string) (time.Duration, bool, error)
+	samples map[string]cpuSample
+}
+
+func newBatchWaiter() *batchWaiter {
+	return &batchWaiter{cpuTime: processCPUTime, samples: map[string]cpuSample{}}
+}
+
+// Idle reports whether the process of exe is not running or has hardly used the CPU since the previous sample.
+// It returns false until two samples are taken at least batchIdleInterval apart.
+func (bw *batchWaiter) Idle(exe string, now time.Time) (bool, error) {
+	cpu, running, err := bw.cpuTime(exe)
+	if err != nil {
+		return false, err
+	}
+	if !running {
+		delete(bw.samples, exe)
+		return true, nil
+	}
+	prev, ok := bw.samples[exe]
+	if ok && now.Sub(prev.At) < batchIdleInterval {
+		return false, nil
+	}
+	bw.samples[exe] = cpuSample{CPU: cpu, At: now}
+	if !ok || cpu < prev.CPU {
+		// the process may have been restarted.
+		return false, nil
+	}
+	return float64(cpu-prev.CPU) <= float64(now.Sub(prev.At))*batchIdleCPU, nil
+}
+
+// findBatchMarkers returns the files in dir that match the marker of the rules.
+func (ss *setting) findBatchMarkers(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	for _, e := range entries {
+		if path := filepath.Join(dir, e.Name()); !e.IsDir() && ss.IsBatchMarker(path) {
+			r = append(r, path)
+		}
+	}
+	return r, nil
+}
+
+// IsBatchMarker reports whether path is the marker file that tells the batch in the folder is completed.
+func (ss *setting) IsBatchMarker(path string) bool {
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	for i := range ss.Rule {
+		r := &ss.Rule[i]
+		if r.batchMarkerRE != nil && r.containsDir(dir, false) && r.batchMarkerRE.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchWaiter(t *testing.T) {
+	var cpu time.Duration
+	running := true
+	bw := &batchWaiter{
+		cpuTime: func(exe string) (time.Duration, bool, error) {
+			return cpu, running, nil
+		},
+		samples: map[string]cpuSample{},
+	}
+	now := time.Now()
+	tests := []struct {
+		Elapsed time.Duration
+		CPU     time.Duration
+		Running bool
+		Want    bool
+	}{
+		{0, 0, true, false}, // first sample
+		{time.Second, 800 * time.Millisecond, true, false},             // busy
+		{1500 * time.Millisecond, 850 * time.Millisecond, true, false}, // too short interval
+		{2 * time.Second, 820 * time.Millisecond, true, true},          // idle
+		{3 * time.Second, 100 * time.Millisecond, true, false},         // restarted
+		{4 * time.Second, 0, false, true},                              // not running
+	}
+	for i, test := range tests {
+		cpu, running = test.CPU, test.Running
+		got, err := bw.Idle("CeVIO AI.exe", now.Add(test.Elapsed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.Want {
+			t.Errorf("No.%d: want %v got %v", i, test.Want, got)
+		}
+	}
+}
+
+func TestBatchMarkers(t *testing.T) {
+	dir := t.TempDir()
+	cevio := filepath.Join(dir, "cevio")
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+dir = '`+cevio+`'
+batchmarker = '*.done'
+
+[[rule]]
+dir = '`+dir+`'
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.wav", "a.txt", "export.done", "sub.done"} {
+		writeTestSetting(t, filepath.Join(cevio, name), "")
+	}
+	if err = os.Mkdir(filepath.Join(cevio, "dir.done"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	markers, err := s.findBatchMarkers(cevio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(markers, ","), filepath.Join(cevio, "export.done")+","+filepath.Join(cevio, "sub.done"); got != want {
+		t.Errorf("want %q got %q", want, got)
+	}
+	if s.IsBatchMarker(filepath.Join(dir, "export.done")) {
+		t.Errorf("marker in the folder without batchmarker is accepted")
+	}
+	if _, err = newSetting(strings.NewReader(`
+[[rule]]
+batchmarker = 'sub\done'
+`), dir, ""); err == nil {
+		t.Errorf("batchmarker with a folder should be rejected")
+	}
+}
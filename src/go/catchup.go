@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// catchUp looks for audio/text pairs (or lone audio files for audio-only rules) that were created while the directories were not watched.
+// The pairs that already recorded on the journal are skipped and logged so that the user can
+// remove the entries by the forget command and save them again.
+func catchUp(setting *setting, dirs map[string]catchUpTarget, j *journal) map[string]struct{} {
+	found := map[string]struct{}{}
+	now := time.Now()
+	for dir, target := range dirs {
+		err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if e.IsDir() {
+				if path != dir && !target.Recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !setting.IsAudioFile(path) {
+				return nil
+			}
+			txtPath := setting.FindTextFile(path)
+			st, err := os.Stat(path)
+			if err != nil || now.Sub(st.ModTime()).Seconds() > target.Age {
+				return nil
+			}
+			if txtPath == "" && !setting.AcceptsLoneAudio(path) {
+				return nil
+			}
+			hash, err := verifyAndCalcHash(path, txtPath, setting.DirOptions(filepath.Dir(path)).AcceptEmptyText)
+			if err != nil {
+				if verbose {
+					log.Println(suppress.Renderln("  取りこぼし確認で読み取れなかったファイルを無視します:", path))
+					log.Println(suppress.Renderln("    理由:", err))
+				}
+				return nil
+			}
+			if j.Handled(path, hash) {
+				log.Println(suppress.Renderln("  処理履歴に記録済みのファイルのため取りこぼし確認の対象外です:", path))
+				log.Println(suppress.Renderln("    もう一度処理するには forcepser.exe forget", filepath.Base(path), "で処理履歴から削除してから保存し直してください"))
+				return nil
+			}
+			found[path] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			log.Println(warn.Renderln("  フォルダー", dir, "の取りこぼし確認に失敗しました:", err))
+		}
+	}
+	return found
+}
+
+// newCatchUpDirs returns the directories in dirs that were not scanned by the previous call.
+// scanned holds the directories of the previous call and is replaced with dirs, so the directories
+// that stay watched across setting reloads are scanned only once, and the ones removed and added again
+// by later reloads are scanned again because they were not watched in the meantime.
+func newCatchUpDirs(dirs map[string]catchUpTarget, scanned map[string]struct{}) map[string]catchUpTarget {
+	r := map[string]catchUpTarget{}
+	current := map[string]struct{}{}
+	for dir, target := range dirs {
+		key := strings.ToLower(filepath.Clean(dir))
+		current[key] = struct{}{}
+		if _, ok := scanned[key]; !ok {
+			r[dir] = target
+		}
+	}
+	for key := range scanned {
+		delete(scanned, key)
+	}
+	for key := range current {
+		scanned[key] = struct{}{}
+	}
+	return r
+}
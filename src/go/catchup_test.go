@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatchUp(t *testing.T) {
+	dir := t.TempDir()
+	watchDir := filepath.Join(dir, "watch")
+	otherDir := filepath.Join(dir, "other")
+	for _, d := range []string{watchDir, otherDir} {
+		if err := os.Mkdir(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := newSetting(strings.NewReader(`
+catchup = 60
+
+[[rule]]
+dir = '%TEMPDIR%'
+
+[[rule]]
+dir = '`+otherDir+`'
+catchup = 0
+`), watchDir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := openJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(dir string, name string, age time.Duration, withText bool) string {
+		wavPath := filepath.Join(dir, name+".wav")
+		writeTestWave(t, wavPath, 4800)
+		if withText {
+			writeTestText(t, changeExt(wavPath, ".txt"), name)
+		}
+		mt := time.Now().Add(-age)
+		if err := os.Chtimes(wavPath, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+		return wavPath
+	}
+	fresh := write(watchDir, "fresh", 0, true)
+	write(watchDir, "old", 2*time.Minute, true)
+	write(watchDir, "notext", 0, false)
+	write(otherDir, "disabled", 0, true)
+	processed := write(watchDir, "processed", 0, true)
+	hash, err := verifyAndCalcHash(processed, changeExt(processed, ".txt"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Record(processed, hash, "", 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	found := catchUp(s, s.CatchUpDirs(), j)
+	if len(found) != 1 {
+		t.Errorf("want 1 file got %d: %v", len(found), found)
+	}
+	if _, ok := found[fresh]; !ok {
+		t.Errorf("%s is not found", fresh)
+	}
+}
+
+func TestNewCatchUpDirs(t *testing.T) {
+	scanned := map[string]struct{}{}
+	tests := []struct {
+		dirs []string
+		want []string
+	}{
+		{[]string{`C:\a`, `C:\b`}, []string{`C:\a`, `C:\b`}},
+		{[]string{`C:\a`, `c:\B`}, nil},
+		{[]string{`C:\a`, `C:\c`}, []string{`C:\c`}},
+		{[]string{`C:\a`, `C:\b`, `C:\c`}, []string{`C:\b`}},
+	}
+	for idx, data := range tests {
+		dirs := map[string]catchUpTarget{}
+		for _, dir := range data.dirs {
+			dirs[dir] = catchUpTarget{Age: 60}
+		}
+		got := newCatchUpDirs(dirs, scanned)
+		if len(got) != len(data.want) {
+			t.Errorf("No.%d: want %v got %v", idx, data.want, got)
+			continue
+		}
+		for _, dir := range data.want {
+			if _, ok := got[dir]; !ok {
+				t.Errorf("No.%d: %s should be scanned: %v", idx, dir, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindBool
+	kindNumber
+	kindInt
+	kindStringArray
+	kindTableArray
+	kindTableMap
+	kindStringTable
+)
+
+// keySchema describes what is accepted as a value of the setting key.
+type keySchema struct {
+	Kind valueKind
+	// Enum lists the accepted values if it is not empty.
+	Enum []string
+	// NotEmpty rejects an empty array.
+	NotEmpty bool
+	// Regexp requires the value to be a valid regular expression.
+	Regexp bool
+	// Dir and File require the path to exist after %BASEDIR% and so on are expanded.
+	Dir  bool
+	File bool
+	// Capture accepts a string that contains %CAP:name% instead of an integer.
+	Capture bool
+	// Keys is the schema of each table in the array of tables or the table of tables.
+	Keys map[string]keySchema
+}
+
+var encodingEnum = []string{"sjis", "utf8", "utf16le", "utf16be", "auto"}
+
+var sortEnum = []string{"moddate", "name", "natural", "ctime", "sortkey", "lua"}
+
+var ruleKeys = map[string]keySchema{
+	"dir":        {Kind: kindString, Dir: true},
+	"recursive":  {Kind: kindBool},
+	"watchmode":  {Kind: kindString, Enum: []string{"notify", "poll"}},
+	"file":       {Kind: kindString},
+	"filere":     {Kind: kindString, Regexp: true},
+	"encoding":   {Kind: kindString, Enum: encodingEnum},
+	"layer":      {Kind: kindInt, Capture: true},
+	"modifier":   {Kind: kindString},
+	"text":       {Kind: kindString, Regexp: true},
+	"userdata":   {Kind: kindString},
+	"audioext":   {Kind: kindStringArray, NotEmpty: true},
+	"textext":    {Kind: kindStringArray, NotEmpty: true},
+	"textfrom":   {Kind: kindString, Enum: []string{"file", "filename", "metadata", "none"}},
+	"deletetext": {Kind: kindBool},
+	"exofile":    {Kind: kindString},
+	"luafile":    {Kind: kindString},
+	"filemove":   {Kind: kindString, Enum: []string{"off", "copy", "move"}},
+	"destdir":    {Kind: kindString},
+	"movedelay":  {Kind: kindNumber},
+	"padding":    {Kind: kindInt},
+	"catchup":    {Kind: kindNumber},
+	"extends":    {Kind: kindString},
+	"continue":   {Kind: kindBool},
+	"project":    {Kind: kindString},
+	"projectre":  {Kind: kindString, Regexp: true},
+
+	"freshness":       {Kind: kindNumber},
+	"delta":           {Kind: kindNumber},
+	"sort":            {Kind: kindString, Enum: sortEnum},
+	"sortdelay":       {Kind: kindNumber},
+	"sortkey":         {Kind: kindString},
+	"sortfunc":        {Kind: kindString},
+	"acceptemptytext": {Kind: kindBool},
+	"batchmarker":     {Kind: kindString},
+	"batchprocess":    {Kind: kindString},
+
+	"minduration": {Kind: kindInt},
+	"maxduration": {Kind: kindInt},
+	"samplerate":  {Kind: kindInt},
+	"channels":    {Kind: kindInt},
+	"bits":        {Kind: kindInt},
+}
+
+var asasKeys = map[string]keySchema{
+	"exe":    {Kind: kindString, File: true},
+	"flags":  {Kind: kindInt},
+	"filter": {Kind: kindString},
+	"folder": {Kind: kindString},
+	"format": {Kind: kindString},
+}
+
+var settingKeys = map[string]keySchema{
+	"basedir":         {Kind: kindString, Dir: true},
+	"delta":           {Kind: kindNumber},
+	"freshness":       {Kind: kindNumber},
+	"movedelay":       {Kind: kindNumber},
+	"padding":         {Kind: kindInt},
+	"exofile":         {Kind: kindString},
+	"luafile":         {Kind: kindString},
+	"filemove":        {Kind: kindString, Enum: []string{"off", "copy", "move"}},
+	"destdir":         {Kind: kindString},
+	"acceptemptytext": {Kind: kindBool},
+	"deletetext":      {Kind: kindBool},
+	"sort":            {Kind: kindString, Enum: sortEnum},
+	"sortdelay":       {Kind: kindNumber},
+	"sortkey":         {Kind: kindString},
+	"sortfunc":        {Kind: kindString},
+	"fairycall":       {Kind: kindString},
+	"offline":         {Kind: kindBool},
+	"catchup":         {Kind: kindNumber},
+	"watchmode":       {Kind: kindString, Enum: []string{"notify", "poll"}},
+	"pollinterval":    {Kind: kindNumber},
+	"audioext":        {Kind: kindStringArray, NotEmpty: true},
+	"textext":         {Kind: kindStringArray, NotEmpty: true},
+	"textfrom":        {Kind: kindString, Enum: []string{"file", "filename", "metadata", "none"}},
+	"rule":            {Kind: kindTableArray, Keys: ruleKeys},
+	"asas":            {Kind: kindTableArray, Keys: asasKeys},
+	"template":        {Kind: kindTableMap, Keys: ruleKeys},
+	"include":         {Kind: kindStringArray},
+	"vars":            {Kind: kindStringTable},
+}
+
+// settingIssue is a problem found by checkSetting.
+type settingIssue struct {
+	// File is the file that has the problem. It is empty if the problem is not in a specific file.
+	File    string
+	Pos     toml.Position
+	Message string
+}
+
+func (si settingIssue) String() string {
+	if si.Pos.Invalid() {
+		return si.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", si.Pos.Line, si.Pos.Col, si.Message)
+}
+
+type settingChecker struct {
+	projectDir string
+	replacer   *expander
+	templates  map[string]*toml.Tree
+	issues     []settingIssue
+
+	// file is the file being checked.
+	file string
+	// included is true while checking the included file.
+	included bool
+}
+
+func newSettingChecker(config *toml.Tree, tempDir string, projectDir string) *settingChecker {
+	c := &settingChecker{projectDir: projectDir}
+	builtins := builtinVars(getString("basedir", config, ""), tempDir, projectDir)
+	vars, _ := config.Get("vars").(*toml.Tree)
+	var err error
+	if c.replacer, err = newExpander(builtins, vars); err != nil {
+		// the error is reported by checkMerged.
+		c.replacer, _ = newExpander(builtins, nil)
+	}
+	if c.templates, err = getTemplates(config); err != nil {
+		c.templates = map[string]*toml.Tree{}
+	}
+	return c
+}
+
+func (c *settingChecker) report(pos toml.Position, format string, a ...interface{}) {
+	c.issues = append(c.issues, settingIssue{File: c.file, Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+// checkFile checks the content of a file and sorts the issues by their positions.
+func (c *settingChecker) checkFile(file string, t *toml.Tree, included bool) {
+	c.file, c.included = file, included
+	start := len(c.issues)
+	c.checkTree(t, settingKeys, "")
+	issues := c.issues[start:]
+	sort.SliceStable(issues, func(i, j int) bool {
+		pi, pj := issues[i].Pos, issues[j].Pos
+		if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Col < pj.Col
+	})
+	c.file, c.included = "", false
+}
+
+// checkMerged reports the errors that are not covered by the schema.
+func (c *settingChecker) checkMerged(config *toml.Tree, tempDir string) {
+	if len(c.issues) > 0 {
+		return
+	}
+	if _, err := newSettingFromTree(config, tempDir, c.projectDir); err != nil {
+		c.report(toml.Position{}, "%v", err)
+	}
+}
+
+// checkSetting validates the setting strictly.
+// Unlike newSetting, it reports the values that would be ignored or replaced by defaults.
+func checkSetting(r io.Reader, tempDir string, projectDir string) ([]settingIssue, error) {
+	config, err := loadTOML(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read setting file: %w", err)
+	}
+	c := newSettingChecker(config, tempDir, projectDir)
+	c.checkFile("", config, false)
+	c.checkMerged(config, tempDir)
+	return c.issues, nil
+}
+
+func (c *settingChecker) checkTree(t *toml.Tree, schema map[string]keySchema, section string) {
+	for _, key := range t.Keys() {
+		path := []string{key}
+		pos := t.GetPositionPath(path)
+		ks, ok := schema[key]
+		if ok && section == "" && c.included && !includedKeys[key] {
+			c.report(pos, "%q は include で読み込むファイルには書けません", key)
+			continue
+		}
+		if !ok {
+			if section == "" {
+				c.report(pos, "不明なキー %q です", key)
+			} else {
+				c.report(pos, "%s セクションの不明なキー %q です", section, key)
+			}
+			continue
+		}
+		c.checkValue(t.GetPath(path), pos, key, ks)
+	}
+	if section == "[[rule]]" && t.Has("file") && t.Has("filere") {
+		c.report(t.GetPosition("filere"), "file と filere は同時に指定できません")
+	}
+	if _, ok := t.Get("extends").(string); ok {
+		if _, err := templateChain(t, c.templates); err != nil {
+			c.report(t.GetPosition("extends"), "extends の指定に問題があります: %v", err)
+		}
+	}
+}
+
+func (c *settingChecker) checkValue(v interface{}, pos toml.Position, key string, ks keySchema) {
+	switch ks.Kind {
+	case kindString:
+		s, ok := v.(string)
+		if !ok {
+			c.report(pos, "%s は文字列で指定してください", key)
+			return
+		}
+		c.checkString(s, pos, key, ks)
+	case kindBool:
+		if _, ok := v.(bool); !ok {
+			c.report(pos, "%s は true か false で指定してください", key)
+		}
+	case kindNumber:
+		switch v.(type) {
+		case int64, float64:
+		default:
+			c.report(pos, "%s は数値で指定してください", key)
+		}
+	case kindInt:
+		if s, ok := v.(string); ok && ks.Capture {
+			if len(capNames(s)) == 0 {
+				c.report(pos, "%s は整数か %%CAP:名前%% を含む文字列で指定してください", key)
+			}
+			return
+		}
+		if _, ok := v.(int64); !ok {
+			c.report(pos, "%s は整数で指定してください", key)
+		}
+	case kindStringArray:
+		if s, ok := v.(string); ok {
+			c.checkString(s, pos, key, ks)
+			return
+		}
+		a, ok := v.([]interface{})
+		if !ok {
+			c.report(pos, "%s は文字列か文字列の配列で指定してください", key)
+			return
+		}
+		if ks.NotEmpty && len(a) == 0 {
+			c.report(pos, "%s は空にできません", key)
+		}
+		for _, vv := range a {
+			s, ok := vv.(string)
+			if !ok {
+				c.report(pos, "%s は文字列か文字列の配列で指定してください", key)
+				return
+			}
+			c.checkString(s, pos, key, ks)
+		}
+	case kindTableArray:
+		a, ok := v.([]*toml.Tree)
+		if !ok {
+			c.report(pos, "%s は [[%s]] セクションとして記述してください", key, key)
+			return
+		}
+		for _, t := range a {
+			c.checkTree(t, ks.Keys, "[["+key+"]]")
+		}
+	case kindStringTable:
+		t, ok := v.(*toml.Tree)
+		if !ok {
+			c.report(pos, "%s は [%s] セクションとして記述してください", key, key)
+			return
+		}
+		for _, name := range t.Keys() {
+			path := []string{name}
+			if _, ok := t.GetPath(path).(string); !ok {
+				c.report(t.GetPositionPath(path), "[%s] の %s は文字列で指定してください", key, name)
+			}
+		}
+	case kindTableMap:
+		t, ok := v.(*toml.Tree)
+		if !ok {
+			c.report(pos, "%s は [%s.<名前>] セクションとして記述してください", key, key)
+			return
+		}
+		for _, name := range t.Keys() {
+			path := []string{name}
+			sub, ok := t.GetPath(path).(*toml.Tree)
+			if !ok {
+				c.report(t.GetPositionPath(path), "%s は [%s.%s] セクションとして記述してください", name, key, name)
+				continue
+			}
+			c.checkTree(sub, ks.Keys, "["+key+"."+name+"]")
+		}
+	}
+}
+
+func (c *settingChecker) checkString(s string, pos toml.Position, key string, ks keySchema) {
+	if len(ks.Enum) > 0 {
+		found := false
+		for _, e := range ks.Enum {
+			if s == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.report(pos, "%s に %q は指定できません（%s のいずれかを指定してください）", key, s, strings.Join(ks.Enum, ", "))
+		}
+	}
+	if ks.Regexp {
+		if _, err := regexp.Compile(s); err != nil {
+			c.report(pos, "%s の正規表現が正しくありません: %v", key, err)
+		}
+	}
+	if ks.Dir || ks.File {
+		if c.projectDir == "" && strings.Contains(s, "%PROJECTDIR%") {
+			// cannot be resolved until a project is opened.
+			return
+		}
+		path := c.replacer.Replace(s)
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil:
+			c.report(pos, "%s に指定されたパスが見つかりません: %s", key, path)
+		case ks.Dir && !fi.IsDir():
+			c.report(pos, "%s に指定されたパスはフォルダーではありません: %s", key, path)
+		case ks.File && fi.IsDir():
+			c.report(pos, "%s に指定されたパスはファイルではありません: %s", key, path)
+		}
+	}
+}
+
+// checkSettingFile validates the setting file, the files included from it
+// and the setting file placed next to the project file.
+func checkSettingFile(path string, tempDir string, projectPath string) ([]settingIssue, error) {
+	config, sf, err := loadSettingTreeWith(path, &settingFiles{lenient: true})
+	if err != nil {
+		return nil, err
+	}
+	pf, err := loadProjectSettingTree(config, sf, path, projectPath)
+	if err != nil {
+		return nil, err
+	}
+	var projectDir string
+	if projectPath != "" {
+		projectDir = filepath.Dir(projectPath)
+	}
+	c := newSettingChecker(config, tempDir, projectDir)
+	for i, file := range sf.Files {
+		// config has the sections merged from the included files, so read each file again.
+		t, err := readTOMLFile(file)
+		if err != nil {
+			return nil, err
+		}
+		c.checkFile(file, t, i > 0 && file != pf)
+	}
+	c.checkMerged(config, tempDir)
+	return c.issues, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSetting(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		Setting string
+		Issues  []string
+	}{
+		{
+			Setting: `
+delta = 15.0
+sort = 'name'
+audioext = ['.wav', '.ogg']
+
+[[rule]]
+dir = '%TEMPDIR%'
+filere = '^(.+)\.wav$'
+layer = 2
+`,
+		},
+		{
+			Setting: `
+detla = 15.0
+sort = 'random'
+offline = 'yes'
+
+[[rule]]
+layer = 1.5
+filre = '*.wav'
+text = '(unclosed'
+encoding = 'euc'
+`,
+			Issues: []string{
+				`2:1: 不明なキー "detla" です`,
+				`3:1: sort に "random" は指定できません`,
+				`4:1: offline は true か false で指定してください`,
+				`7:1: layer は整数で指定してください`,
+				`8:1: [[rule]] セクションの不明なキー "filre" です`,
+				`9:1: text の正規表現が正しくありません`,
+				`10:1: encoding に "euc" は指定できません`,
+			},
+		},
+		{
+			Setting: `
+[[rule]]
+filere = '^(?P<layer>\d+)_.+\.wav$'
+layer = '%CAP:layer%'
+
+[[rule]]
+layer = 'two'
+`,
+			Issues: []string{
+				`7:1: layer は整数か %CAP:名前% を含む文字列で指定してください`,
+			},
+		},
+		{
+			Setting: `
+audioext = []
+
+[[rule]]
+dir = '%TEMPDIR%\notfound'
+file = '*.wav'
+filere = '.+\.wav'
+
+[[asas]]
+exe = 'notfound.exe'
+`,
+			Issues: []string{
+				`2:1: audioext は空にできません`,
+				`5:1: dir に指定されたパスが見つかりません`,
+				`7:1: file と filere は同時に指定できません`,
+				`10:1: exe に指定されたパスが見つかりません`,
+			},
+		},
+		{
+			Setting: `
+destdir = '%PROJECTDIR%'
+
+[rule]
+dir = '%PROJECTDIR%'
+`,
+			Issues: []string{
+				`4:1: rule は [[rule]] セクションとして記述してください`,
+			},
+		},
+		{
+			Setting: `
+[template.base]
+encoding = 'utf8'
+layr = 2
+
+[[rule]]
+extends = 'bsae'
+`,
+			Issues: []string{
+				`4:1: [template.base] セクションの不明なキー "layr" です`,
+				`7:1: extends の指定に問題があります: template "bsae" is not found`,
+			},
+		},
+	}
+	for i, test := range tests {
+		issues, err := checkSetting(strings.NewReader(strings.ReplaceAll(test.Setting, `\notfound`, string(filepath.Separator)+"notfound")), dir, "")
+		if err != nil {
+			t.Fatalf("No.%d: %v", i, err)
+		}
+		if len(issues) != len(test.Issues) {
+			t.Errorf("No.%d: want %d issues got %v", i, len(test.Issues), issues)
+			continue
+		}
+		for j, issue := range issues {
+			if !strings.HasPrefix(issue.String(), test.Issues[j]) {
+				t.Errorf("No.%d-%d: want %q got %q", i, j, test.Issues[j], issue.String())
+			}
+		}
+	}
+}
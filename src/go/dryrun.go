@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// dryRunDropper logs the drops instead of sending them to AviUtl.
+//
+// The project information is read from ProjectFile if it is specified.
+// Otherwise it uses the wrapped dropper, and falls back to the last known
+// project remembered by projectRecorder.
+// The dropped files are recorded by rec so that the generated EXO files can be examined later.
+type dryRunDropper struct {
+	d   *projectRecorder
+	rec *standInDropper
+}
+
+func newDryRunDropper(d *projectRecorder, projectFile string, outDir string) (*dryRunDropper, error) {
+	rec, err := newStandInDropper(projectFile, outDir)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunDropper{
+		d:   d,
+		rec: rec,
+	}, nil
+}
+
+func (dd *dryRunDropper) GCMZDropsData() (*gcmzDropsData, error) {
+	if dd.rec.ProjectFile != "" {
+		return dd.rec.GCMZDropsData()
+	}
+	proj, err := dd.d.GCMZDropsData()
+	if err == nil && proj.Width != 0 {
+		return proj, nil
+	}
+	last, err2 := dd.d.LastProject()
+	if err2 != nil {
+		if !errors.Is(err2, os.ErrNotExist) {
+			return nil, fmt.Errorf("最後に開いていたプロジェクトの情報が読み込めません: %w", err2)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return proj, nil
+	}
+	return last, nil
+}
+
+func (dd *dryRunDropper) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	copies, err := dd.rec.record(window, layer, frameAdv, files)
+	if err != nil {
+		return fmt.Errorf("ドロップ内容の記録に失敗しました: %w", err)
+	}
+	log.Println(info.Renderln("  [ドライラン] レイヤー", layer, "へのドロップを省略しました"), suppress.Renderln("(長さ:", frameAdv, "フレーム)"))
+	for _, c := range copies {
+		log.Println(suppress.Renderln("    生成されたファイル:"), c)
+	}
+	return nil
+}
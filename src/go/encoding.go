@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// detectEncoding guesses the encoding of the text for encoding = 'auto'.
+//
+// The BOM is used if present. Otherwise UTF-16 is detected by NUL bytes,
+// then UTF-8 is validated. Only the text that is not valid UTF-8 is checked
+// for the characters that commonly appear in Japanese UTF-16 text,
+// and Shift_JIS is used as the last resort.
+func detectEncoding(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xef, 0xbb, 0xbf}):
+		return "utf8"
+	case bytes.HasPrefix(raw, []byte{0xff, 0xfe}):
+		return "utf16le"
+	case bytes.HasPrefix(raw, []byte{0xfe, 0xff}):
+		return "utf16be"
+	}
+	even := len(raw) >= 2 && len(raw)%2 == 0
+	if even && bytes.IndexByte(raw, 0) != -1 {
+		// ASCII characters in UTF-16 have a NUL byte on the upper side.
+		var lo, hi int
+		for i := 0; i < len(raw); i += 2 {
+			if raw[i] == 0 {
+				lo++
+			}
+			if raw[i+1] == 0 {
+				hi++
+			}
+		}
+		if hi >= lo {
+			return "utf16le"
+		}
+		return "utf16be"
+	}
+	if utf8.Valid(raw) {
+		// short ASCII text such as "2020" is also valid UTF-16.
+		return "utf8"
+	}
+	if even {
+		if looksLikeJapaneseUTF16(raw, 1, 0) {
+			return "utf16le"
+		}
+		if looksLikeJapaneseUTF16(raw, 0, 1) {
+			return "utf16be"
+		}
+	}
+	return "sjis"
+}
+
+// looksLikeJapaneseUTF16 reports whether all code units of raw are the characters
+// that commonly appear in Japanese text and at least one of them is kana or CJK punctuation.
+// hi and lo are the offsets of the upper and the lower byte in a code unit.
+func looksLikeJapaneseUTF16(raw []byte, hi int, lo int) bool {
+	var kana bool
+	for i := 0; i < len(raw); i += 2 {
+		c := rune(raw[i+hi])<<8 | rune(raw[i+lo])
+		switch {
+		case c >= 0x3000 && c <= 0x30ff:
+			// CJK symbols and punctuation, hiragana and katakana
+			kana = true
+		case c >= 0x4e00 && c <= 0x9fff:
+			// CJK unified ideographs
+		case c >= 0xff00 && c <= 0xffef:
+			// halfwidth and fullwidth forms
+		case c >= 0x20 && c <= 0x7e, c == '\t', c == '\r', c == '\n':
+		default:
+			return false
+		}
+	}
+	return kana
+}
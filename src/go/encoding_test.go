@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	// encodeText writes the BOM for UTF-16.
+	mustEncode := func(s string, encoding string) []byte {
+		var b []byte
+		var err error
+		switch encoding {
+		case "utf16le":
+			b, err = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+		case "utf16be":
+			b, err = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+		default:
+			b, err = encodeText(s, encoding)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		raw  []byte
+		want string
+	}{
+		{[]byte("\xef\xbb\xbfこんにちは"), "utf8"},
+		{append([]byte{0xff, 0xfe}, mustEncode("こんにちは", "utf16le")...), "utf16le"},
+		{append([]byte{0xfe, 0xff}, mustEncode("こんにちは", "utf16be")...), "utf16be"},
+		{mustEncode("hello", "utf16le"), "utf16le"},
+		{mustEncode("hello", "utf16be"), "utf16be"},
+		{mustEncode("東北きりたん＞こんにちは。", "utf16le"), "utf16le"},
+		{mustEncode("東北きりたん＞こんにちは。", "utf16be"), "utf16be"},
+		{mustEncode("東北きりたん＞こんにちは。", "utf8"), "utf8"},
+		{mustEncode("東北きりたん＞こんにちは。", "sjis"), "sjis"},
+		{mustEncode("ソ連の表示", "sjis"), "sjis"},
+		{[]byte("hello"), "utf8"},
+		{[]byte("hi"), "utf8"},
+		{[]byte("2020"), "utf8"},
+		{[]byte("10"), "utf8"},
+		{[]byte("Go10"), "utf8"},
+		{[]byte("OK"), "utf8"},
+		{[]byte{}, "utf8"},
+	}
+	for idx, data := range tests {
+		if got := detectEncoding(data.raw); got != data.want {
+			t.Errorf("No.%d: want %s got %s", idx, data.want, got)
+		}
+	}
+}
+
+func TestFindAutoEncoding(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+encoding = 'auto'
+text = '^きりたん＞'
+layer = 1
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, encoding := range []string{"sjis", "utf8", "utf16le", "utf16be"} {
+		wavPath := filepath.Join(dir, encoding+".wav")
+		writeTestWave(t, wavPath, 100)
+		b, err := encodeText("きりたん＞こんにちは", encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(changeExt(wavPath, ".txt"), b, 0666); err != nil {
+			t.Fatal(err)
+		}
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want match got no rule", idx)
+			continue
+		}
+		if m.Encoding != encoding || m.Text != "きりたん＞こんにちは" {
+			t.Errorf("No.%d: want %s %q got %s %q", idx, encoding, "きりたん＞こんにちは", m.Encoding, m.Text)
+		}
+	}
+
+	if _, err = newSetting(strings.NewReader("[[rule]]\nencoding = 'euc-jp'"), dir, ""); err == nil || err.Error() != "unknown encoding: euc-jp" {
+		t.Errorf("want unknown encoding error got %v", err)
+	}
+}
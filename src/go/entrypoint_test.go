@@ -0,0 +1,522 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/text/encoding/japanese"
+)
+
+type entrypointEnv struct {
+	Dir         string
+	WatchDir    string
+	DropDir     string
+	ProjectFile string
+	Entrypoint  string
+	Setting     *setting
+	Dropper     *standInDropper
+	Journal     *journal
+	L           *lua.LState
+}
+
+func newEntrypointEnv(t *testing.T, settingText string) *entrypointEnv {
+	t.Helper()
+	entrypoint, err := filepath.Abs(filepath.Join("..", "lua", "_entrypoint.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	env := &entrypointEnv{
+		Dir:         dir,
+		WatchDir:    filepath.Join(dir, "watch"),
+		DropDir:     filepath.Join(dir, "drops"),
+		ProjectFile: filepath.Join(dir, "project.json"),
+		Entrypoint:  entrypoint,
+	}
+	if err = os.Mkdir(env.WatchDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	env.writeProject(t, true)
+	env.Dropper, err = newStandInDropper(env.ProjectFile, env.DropDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Journal, err = openJournal(filepath.Join(dir, "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	env.Setting, err = newSetting(strings.NewReader(settingText), env.WatchDir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.L, err = newLuaState(env.Setting, env.Dropper, entrypoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(env.L.Close)
+	return env
+}
+
+func (env *entrypointEnv) writeProject(t *testing.T, open bool) {
+	t.Helper()
+	proj := gcmzDropsData{
+		Window:      1,
+		GCMZAPIVer:  2,
+		ProjectFile: filepath.Join(env.Dir, "project.aup"),
+	}
+	if open {
+		proj.Width = 1920
+		proj.Height = 1080
+		proj.VideoRate = 30
+		proj.VideoScale = 1
+		proj.AudioRate = 48000
+		proj.AudioCh = 2
+	}
+	b, err := json.Marshal(proj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(env.ProjectFile, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (env *entrypointEnv) writeVoice(t *testing.T, name string, text string) file {
+	t.Helper()
+	wavPath := filepath.Join(env.WatchDir, name+".wav")
+	writeTestWave(t, wavPath, 48000)
+	writeTestText(t, changeExt(wavPath, ".txt"), text)
+	return file{Filepath: wavPath, Hash: name, ModDate: time.Now()}
+}
+
+func (env *entrypointEnv) readDrop(t *testing.T, name string) (standInDrop, string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(env.DropDir, name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var drop standInDrop
+	if err = json.Unmarshal(b, &drop); err != nil {
+		t.Fatal(err)
+	}
+	if len(drop.Copies) != 1 {
+		t.Fatalf("%s: want 1 file got %d", name, len(drop.Copies))
+	}
+	b, err = os.ReadFile(filepath.Join(env.DropDir, drop.Copies[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exo, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return drop, string(exo)
+}
+
+func TestEntrypointDrop(t *testing.T) {
+	env := newEntrypointEnv(t, `
+filemove = 'move'
+deletetext = true
+padding = 0
+
+[[rule]]
+file = '*_きりたん_*.wav'
+encoding = 'utf8'
+layer = 3
+modifier = '''
+  filename = "renamed.wav"
+'''
+
+[[rule]]
+encoding = 'utf8'
+layer = 5
+`)
+	var files []file
+	for _, name := range []string{"1_きりたん_こんにちは", "2_ずんだもん_こんばんは"} {
+		files = append(files, env.writeVoice(t, name, name))
+	}
+	recentChanged := map[string]fileState{}
+	recentSent := map[string]sentFileState{}
+	needRetry, err := processFiles(env.L, env.Dropper, files, "name", "", recentChanged, recentSent, env.Journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needRetry {
+		t.Errorf("needRetry: want false got true")
+	}
+
+	drop, exo := env.readDrop(t, "000001")
+	if drop.Layer != 3 || drop.FrameAdvance != 30 || drop.Window != 1 {
+		t.Errorf("000001: unexpected drop %+v", drop)
+	}
+	renamed := filepath.Join(env.Dir, "renamed.wav")
+	if !strings.Contains(exo, "file="+renamed+"\r\n") {
+		t.Errorf("000001: exo does not refer %s:\n%s", renamed, exo)
+	}
+	if !exists(renamed) || exists(filepath.Join(env.WatchDir, "1_きりたん_こんにちは.wav")) {
+		t.Errorf("000001: file is not moved")
+	}
+	if exists(filepath.Join(env.Dir, "renamed.txt")) {
+		t.Errorf("000001: text file is not deleted")
+	}
+	if _, ok := recentSent[renamed]; !ok {
+		t.Errorf("000001: dest is not recorded on recentSent")
+	}
+	if e, ok := env.Journal.Processed(renamed, files[0].Hash); !ok || e.Rule != 1 || e.Src != files[0].Filepath {
+		t.Errorf("000001: unexpected journal entry %+v", e)
+	}
+
+	if e, ok := env.Journal.Processed(files[1].Filepath, files[1].Hash); !ok || e.Rule != 2 {
+		t.Errorf("000002: unexpected journal entry %+v", e)
+	}
+
+	drop, exo = env.readDrop(t, "000002")
+	if drop.Layer != 5 {
+		t.Errorf("000002: unexpected drop %+v", drop)
+	}
+	if err = env.L.DoString(`exotext = toexostring("2_ずんだもん_こんばんは")`); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(exo, "text="+env.L.GetGlobal("exotext").String()) {
+		t.Errorf("000002: exo does not contain text:\n%s", exo)
+	}
+}
+
+func TestEntrypointOfflineQueue(t *testing.T) {
+	env := newEntrypointEnv(t, `
+[[rule]]
+encoding = 'utf8'
+layer = 2
+`)
+	od, err := newOfflineDropper(newProjectRecorder(env.Dropper, filepath.Join(env.Dir, lastProjectName)), filepath.Join(env.Dir, "offline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	L, err := newLuaState(env.Setting, od, env.Entrypoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer L.Close()
+
+	// remember the project while AviUtl is running.
+	if _, err = od.GCMZDropsData(); err != nil {
+		t.Fatal(err)
+	}
+	env.writeProject(t, false)
+
+	files := []file{env.writeVoice(t, "1", "one"), env.writeVoice(t, "2", "two")}
+	if _, err = processFiles(L, od, files[:1], "moddate", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = processFiles(L, od, files[1:], "moddate", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	if od.Len() != 2 {
+		t.Errorf("queue length: want %d got %d", 2, od.Len())
+	}
+	if exists(filepath.Join(env.DropDir, "000001.json")) {
+		t.Errorf("drop should be queued while offline")
+	}
+
+	// the queue survives restarts.
+	od, err = newOfflineDropper(newProjectRecorder(env.Dropper, filepath.Join(env.Dir, lastProjectName)), filepath.Join(env.Dir, "offline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if od.Len() != 2 {
+		t.Errorf("restored queue length: want %d got %d", 2, od.Len())
+	}
+	if err = od.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if od.Len() != 2 {
+		t.Errorf("queue should be kept while offline")
+	}
+
+	// the drops are not delivered to the other project.
+	other, err := json.Marshal(gcmzDropsData{Window: 1, Width: 1280, Height: 720, VideoRate: 60, VideoScale: 1, AudioRate: 44100, AudioCh: 2, GCMZAPIVer: 2, ProjectFile: filepath.Join(env.Dir, "other.aup")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(env.ProjectFile, other, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err = od.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if od.Len() != 2 || exists(filepath.Join(env.DropDir, "000001.json")) {
+		t.Errorf("queue should be kept while the other project is open")
+	}
+
+	env.writeProject(t, true)
+	if err = od.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if od.Len() != 0 {
+		t.Errorf("queue length after flush: want %d got %d", 0, od.Len())
+	}
+	for i, name := range []string{"1", "2"} {
+		drop, exo := env.readDrop(t, fmt.Sprintf("%06d", i+1))
+		if drop.Layer != 2 || drop.Window != 1 {
+			t.Errorf("%s: unexpected drop %+v", name, drop)
+		}
+		if !strings.Contains(exo, filepath.Join(env.WatchDir, name+".wav")) {
+			t.Errorf("%s: drops are not delivered in order:\n%s", name, exo)
+		}
+	}
+}
+
+func TestEntrypointAudioOnly(t *testing.T) {
+	env := newEntrypointEnv(t, `
+[[rule]]
+filere = '^se_(.+)\.wav$'
+textfrom = 'filename'
+layer = 4
+`)
+	wavPath := filepath.Join(env.WatchDir, "se_拍手.wav")
+	writeTestWave(t, wavPath, 4800)
+	hash, err := verifyAndCalcHash(wavPath, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []file{{Filepath: wavPath, Hash: hash, ModDate: time.Now()}}
+	if _, err = processFiles(env.L, env.Dropper, files, "moddate", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	drop, exo := env.readDrop(t, "000001")
+	if drop.Layer != 4 || drop.FrameAdvance != 3 {
+		t.Errorf("unexpected drop %+v", drop)
+	}
+	if err = env.L.DoString(`exotext = toexostring("拍手")`); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(exo, "text="+env.L.GetGlobal("exotext").String()) {
+		t.Errorf("exo does not contain text from filename:\n%s", exo)
+	}
+}
+
+func TestEntrypointDryRun(t *testing.T) {
+	env := newEntrypointEnv(t, `
+filemove = 'move'
+deletetext = true
+padding = 0
+
+[[rule]]
+encoding = 'utf8'
+layer = 3
+modifier = '''
+  filename = "renamed.wav"
+'''
+`)
+	dryRun = true
+	defer func() { dryRun = false }()
+	dd, err := newDryRunDropper(newProjectRecorder(env.Dropper, filepath.Join(env.Dir, lastProjectName)), env.ProjectFile, filepath.Join(env.Dir, "dryrun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	L, err := newLuaState(env.Setting, dd, env.Entrypoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer L.Close()
+
+	files := []file{env.writeVoice(t, "1", "one")}
+	if _, err = processFiles(L, dd, files, "name", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(files[0].Filepath) || !exists(changeExt(files[0].Filepath, ".txt")) {
+		t.Errorf("source files should be kept")
+	}
+	if exists(filepath.Join(env.Dir, "renamed.wav")) {
+		t.Errorf("file should not be moved")
+	}
+	if exists(filepath.Join(env.DropDir, "000001.json")) {
+		t.Errorf("file should not be dropped")
+	}
+	if _, ok := env.Journal.Processed(filepath.Join(env.Dir, "renamed.wav"), files[0].Hash); ok {
+		t.Errorf("journal should not be written")
+	}
+
+	env.DropDir = filepath.Join(env.Dir, "dryrun")
+	drop, exo := env.readDrop(t, "000001")
+	if drop.Layer != 3 || drop.FrameAdvance != 30 {
+		t.Errorf("unexpected drop %+v", drop)
+	}
+	renamed := filepath.Join(env.Dir, "renamed.wav")
+	if !strings.Contains(exo, "file="+renamed+"\r\n") {
+		t.Errorf("exo does not refer %s:\n%s", renamed, exo)
+	}
+}
+
+func TestDryRunLastProject(t *testing.T) {
+	env := newEntrypointEnv(t, `
+[[rule]]
+encoding = 'utf8'
+`)
+	last := filepath.Join(env.Dir, "tmp", lastProjectName)
+	pr := newProjectRecorder(env.Dropper, last)
+	dd, err := newDryRunDropper(pr, "", filepath.Join(env.Dir, "dryrun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is remembered before the project is read.
+	env.writeProject(t, false)
+	proj, err := dd.GCMZDropsData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proj.Width != 0 || exists(last) {
+		t.Errorf("closed project should not be remembered: %+v", proj)
+	}
+
+	// the project read in any mode is used while AviUtl is not running.
+	env.writeProject(t, true)
+	if _, err = pr.GCMZDropsData(); err != nil {
+		t.Fatal(err)
+	}
+	env.writeProject(t, false)
+	if proj, err = dd.GCMZDropsData(); err != nil {
+		t.Fatal(err)
+	}
+	if proj.Width != 1920 || proj.Window != 0 {
+		t.Errorf("unexpected last project %+v", proj)
+	}
+}
+
+func TestEntrypointDestDirTokens(t *testing.T) {
+	env := newEntrypointEnv(t, `
+filemove = 'move'
+padding = 0
+
+[[rule]]
+filere = '^\d+_(?P<char>[^_]+)_.+\.wav$'
+destdir = '%PROJECTDIR%/voice/%PROJECTNAME%/%CAP:char%/%RULEINDEX%'
+encoding = 'utf8'
+layer = 3
+`)
+	files := []file{env.writeVoice(t, "1_きりたん_こんにちは", "こんにちは")}
+	if _, err := processFiles(env.L, env.Dropper, files, "name", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(env.Dir, "voice", "project", "きりたん", "1", "1_きりたん_こんにちは.wav")
+	if !exists(moved) {
+		t.Errorf("file is not moved to %s", moved)
+	}
+	_, exo := env.readDrop(t, "000001")
+	if !strings.Contains(exo, "file="+moved+"\r\n") {
+		t.Errorf("exo does not refer %s:\n%s", moved, exo)
+	}
+}
+
+func TestEntrypointContinue(t *testing.T) {
+	env := newEntrypointEnv(t, `
+filemove = 'move'
+padding = 0
+
+[[rule]]
+file = '*_きりたん_*.wav'
+encoding = 'utf8'
+layer = 3
+continue = true
+
+[[rule]]
+file = '*_きりたん_*.wav'
+encoding = 'utf8'
+layer = 7
+destdir = '%PROJECTDIR%/portrait'
+modifier = '''
+  filename = "portrait.wav"
+'''
+
+[[rule]]
+encoding = 'utf8'
+layer = 9
+`)
+	files := []file{env.writeVoice(t, "1_きりたん_こんにちは", "こんにちは")}
+	if _, err := processFiles(env.L, env.Dropper, files, "name", "", map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		drop  string
+		layer int
+		path  string
+		rule  int
+	}{
+		{"000001", 3, filepath.Join(env.Dir, "1_きりたん_こんにちは.wav"), 1},
+		{"000002", 7, filepath.Join(env.Dir, "portrait", "portrait.wav"), 2},
+	}
+	for idx, data := range tests {
+		drop, exo := env.readDrop(t, data.drop)
+		if drop.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, drop.Layer)
+		}
+		if !strings.Contains(exo, "file="+data.path+"\r\n") {
+			t.Errorf("No.%d: exo does not refer %s:\n%s", idx, data.path, exo)
+		}
+		if !exists(data.path) {
+			t.Errorf("No.%d: %s does not exist", idx, data.path)
+		}
+		if e, ok := env.Journal.Processed(data.path, files[0].Hash); !ok || e.Rule != data.rule {
+			t.Errorf("No.%d: unexpected journal entry %+v", idx, e)
+		}
+	}
+	if exists(filepath.Join(env.DropDir, "000003.json")) {
+		t.Errorf("rule 3 must not be used")
+	}
+}
+
+func TestEntrypointSort(t *testing.T) {
+	tests := []struct {
+		Sort    string
+		Setting string
+		Want    []string
+	}{
+		{"name", "", []string{"10_a", "2_b", "１_c"}},
+		{"natural", "", []string{"１_c", "2_b", "10_a"}},
+		{"lua", "sortfunc = 'return a.path > b.path'", []string{"１_c", "2_b", "10_a"}},
+		{"sortkey", `filere = '^.+_(?P<key>.)\.wav$'` + "\nsortkey = '%CAP:key%'", []string{"10_a", "2_b", "１_c"}},
+	}
+	for i, test := range tests {
+		// newEntrypointEnv changes the working directory until the test ends.
+		t.Run(test.Sort, func(t *testing.T) {
+			env := newEntrypointEnv(t, `
+[[rule]]
+encoding = 'utf8'
+sort = '`+test.Sort+`'
+`+test.Setting)
+			var files []file
+			for _, name := range []string{"2_b", "10_a", "１_c"} {
+				f := env.writeVoice(t, name, name)
+				f.SortKey = env.Setting.FileSortKey(f.Filepath)
+				files = append(files, f)
+			}
+			opts := env.Setting.DirOptions(env.WatchDir)
+			if _, err := processFiles(env.L, env.Dropper, files, opts.Sort, opts.SortFunc, map[string]fileState{}, map[string]sentFileState{}, env.Journal); err != nil {
+				t.Fatal(err)
+			}
+			for j, name := range test.Want {
+				_, exo := env.readDrop(t, fmt.Sprintf("%06d", j+1))
+				if !strings.Contains(exo, name+".wav") {
+					t.Errorf("No.%d: drop %d: want %s", i, j+1, name)
+				}
+			}
+		})
+	}
+}
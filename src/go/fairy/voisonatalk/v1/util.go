@@ -1,3 +1,5 @@
+//go:build windows
+
 package voisonatalk
 
 func match(s string, patterns []string) bool {
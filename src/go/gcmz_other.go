@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+var errGCMZDropsUnsupported = errors.New("ごちゃまぜドロップスの外部連携APIはこのプラットフォームでは使用できません")
+
+func readGCMZDropsData() (*gcmzDropsData, error) {
+	return nil, errGCMZDropsUnsupported
+}
+
+type gcmzDropper struct{}
+
+func (gcmzDropper) GCMZDropsData() (*gcmzDropsData, error) {
+	return readGCMZDropsData()
+}
+
+func (gcmzDropper) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	return errGCMZDropsUnsupported
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var modKernel32 = windows.NewLazySystemDLL("kernel32.dll")
+var modUser32 = windows.NewLazySystemDLL("user32.dll")
+var modShell32 = windows.NewLazySystemDLL("shell32.dll")
+
+var procOpenFileMappingW = modKernel32.NewProc("OpenFileMappingW")
+var procGetConsoleWindow = modKernel32.NewProc("GetConsoleWindow")
+var procSendMessageW = modUser32.NewProc("SendMessageW")
+var procSetForegroundWindow = modUser32.NewProc("SetForegroundWindow")
+var procSHGetSpecialFolderPath = modShell32.NewProc("SHGetSpecialFolderPathW")
+
+func openFileMapping(desiredAccess uint32, inheritHandle uint32, name *uint16) (handle windows.Handle, err error) {
+	r0, _, e1 := syscall.Syscall(procOpenFileMappingW.Addr(), 3, uintptr(desiredAccess), uintptr(inheritHandle), uintptr(unsafe.Pointer(name)))
+	handle = windows.Handle(r0)
+	if handle == 0 {
+		if e1 != 0 {
+			err = e1
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
+func getConsoleWindow() (handle windows.HWND) {
+	r0, _, _ := syscall.Syscall(procGetConsoleWindow.Addr(), 0, 0, 0, 0)
+	handle = windows.HWND(r0)
+	return
+}
+
+func sendMessage(hwnd windows.Handle, uMsg uint32, wParam uintptr, lParam uintptr) (lResult uintptr, err error) {
+	r0, _, e1 := syscall.Syscall6(procSendMessageW.Addr(), 4, uintptr(hwnd), uintptr(uMsg), uintptr(wParam), uintptr(lParam), 0, 0)
+	lResult = uintptr(r0)
+	if e1 != 0 {
+		err = e1
+	}
+	return
+}
+
+func setForegroundWindow(hwnd windows.HWND) bool {
+	r0, _, _ := syscall.Syscall(procSetForegroundWindow.Addr(), 1, uintptr(hwnd), 0, 0)
+	return r0 != 0
+}
+
+func readGCMZDropsData() (*gcmzDropsData, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	fileMappingName, err := windows.UTF16PtrFromString("GCMZDrops")
+	if err != nil {
+		return nil, err
+	}
+	mutexName, err := windows.UTF16PtrFromString("GCMZDropsMutex")
+	if err != nil {
+		return nil, err
+	}
+
+	fmo, err := openFileMapping(windows.FILE_MAP_READ, 0, fileMappingName)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(fmo)
+
+	p, err := windows.MapViewOfFile(fmo, windows.FILE_MAP_READ, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.UnmapViewOfFile(p)
+
+	var oldAPI = false
+	mutex, err := windows.OpenMutex(windows.MUTEX_ALL_ACCESS, false, mutexName)
+	if err != nil {
+		oldAPI = true
+	} else {
+		defer windows.CloseHandle(mutex)
+		windows.WaitForSingleObject(mutex, windows.INFINITE)
+		defer windows.ReleaseMutex(mutex)
+	}
+
+	var m []byte
+	mh := (*reflect.SliceHeader)(unsafe.Pointer(&m))
+	mh.Data = p
+	mh.Len = 32 + windows.MAX_PATH*2 + 4
+	mh.Cap = mh.Len
+	r := &gcmzDropsData{
+		Window:     uintptr(binary.LittleEndian.Uint32(m[0:])),
+		Width:      int(int32(binary.LittleEndian.Uint32(m[4:]))),
+		Height:     int(int32(binary.LittleEndian.Uint32(m[8:]))),
+		VideoRate:  int(int32(binary.LittleEndian.Uint32(m[12:]))),
+		VideoScale: int(int32(binary.LittleEndian.Uint32(m[16:]))),
+		AudioRate:  int(int32(binary.LittleEndian.Uint32(m[20:]))),
+		AudioCh:    int(int32(binary.LittleEndian.Uint32(m[24:]))),
+	}
+	if !oldAPI {
+		r.GCMZAPIVer = int(int32(binary.LittleEndian.Uint32(m[28:])))
+		r.ProjectFile = windows.UTF16PtrToString((*uint16)(unsafe.Pointer(&m[32])))
+		if r.GCMZAPIVer >= 2 {
+			r.Flags = int(binary.LittleEndian.Uint32(m[32+windows.MAX_PATH*2:]))
+		}
+	}
+	return r, nil
+}
+
+type gcmzDropper struct{}
+
+func (gcmzDropper) GCMZDropsData() (*gcmzDropsData, error) {
+	return readGCMZDropsData()
+}
+
+func (gcmzDropper) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, strconv.Itoa(layer)...)
+	buf = append(buf, 0x00)
+	buf = append(buf, strconv.Itoa(frameAdv)...)
+	for _, f := range files {
+		buf = append(buf, 0x00)
+		buf = append(buf, f...)
+	}
+
+	str := utf16.Encode([]rune(string(buf)))
+
+	const wmCopyData = 0x4A
+	type copyDataStruct struct {
+		Data uintptr
+		Size uint32
+		Ptr  uintptr
+	}
+	cds := &copyDataStruct{
+		Data: 0,
+		Size: uint32(len(str) * 2),
+		Ptr:  uintptr(unsafe.Pointer(&str[0])),
+	}
+	_, err := sendMessage(windows.Handle(window), wmCopyData, uintptr(getConsoleWindow()), uintptr(unsafe.Pointer(cds)))
+	return err
+}
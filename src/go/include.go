@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// includedKeys is the keys that can be written in the included files.
+var includedKeys = map[string]bool{
+	"rule":     true,
+	"asas":     true,
+	"template": true,
+	"include":  true,
+}
+
+func readTOMLFile(path string) (*toml.Tree, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := loadTOML(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return t, nil
+}
+
+// settingFiles is the result of resolving include.
+type settingFiles struct {
+	// Files is the absolute paths of the setting file and the included files in the order of reading.
+	Files []string
+	// Patterns is the include patterns in absolute path.
+	Patterns []string
+
+	// lenient ignores the keys that cannot be used in the included files.
+	// checkSettingFile reports them instead.
+	lenient bool
+	visited map[string]bool
+}
+
+// loadSettingTree reads the setting file and merges [[rule]], [[asas]] and [template.<name>] of the included files into it.
+func loadSettingTree(path string) (*toml.Tree, *settingFiles, error) {
+	return loadSettingTreeWith(path, &settingFiles{})
+}
+
+func loadSettingTreeWith(path string, sf *settingFiles) (*toml.Tree, *settingFiles, error) {
+	config, err := readTOMLFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sf.Files = []string{path}
+	sf.visited = map[string]bool{strings.ToLower(path): true}
+	if err = sf.include(config, path, config); err != nil {
+		return nil, nil, err
+	}
+	return config, sf, nil
+}
+
+func (sf *settingFiles) include(dst *toml.Tree, path string, t *toml.Tree) error {
+	for _, pattern := range getStringArray("include", t, nil) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		sf.Patterns = append(sf.Patterns, pattern)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include pattern %q: %w", filepath.Base(path), pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("%s: included file %s is not found", filepath.Base(path), pattern)
+		}
+		for _, m := range matches {
+			key := strings.ToLower(m)
+			if sf.visited[key] {
+				continue
+			}
+			sf.visited[key] = true
+			it, err := readTOMLFile(m)
+			if err != nil {
+				return err
+			}
+			sf.Files = append(sf.Files, m)
+			if err = mergeIncluded(dst, it, sf.lenient); err != nil {
+				return fmt.Errorf("%s: %w", filepath.Base(m), err)
+			}
+			if err = sf.include(dst, m, it); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeIncluded appends the sections of the included file to dst.
+func mergeIncluded(dst *toml.Tree, t *toml.Tree, lenient bool) error {
+	for _, key := range t.Keys() {
+		if !includedKeys[key] && !lenient {
+			return fmt.Errorf("%s cannot be used in included files", key)
+		}
+	}
+	for _, key := range []string{"rule", "asas"} {
+		if !t.Has(key) {
+			continue
+		}
+		a := append(getSubTreeArray(key, dst), getSubTreeArray(key, t)...)
+		dst.SetPath([]string{key}, a)
+	}
+	templates, err := getTemplates(t)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	if _, err = getTemplates(dst); err != nil {
+		return err
+	}
+	for _, name := range t.GetPath([]string{"template"}).(*toml.Tree).Keys() {
+		path := []string{"template", name}
+		if dst.HasPath(path) {
+			return fmt.Errorf("template %q is already defined", name)
+		}
+		dst.SetPath(path, templates[name])
+	}
+	return nil
+}
+
+// projectSettingName is the name of the setting file placed next to the project file.
+const projectSettingName = "setting.txt"
+
+// projectSettingFile returns the path of the setting file placed next to the project file.
+func projectSettingFile(projectPath string) string {
+	if projectPath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(projectPath), projectSettingName)
+}
+
+// overlaySetting applies the project setting t on top of dst.
+// [[rule]] of t are evaluated before the ones of dst, [[asas]] are appended and
+// the other values including [template.<name>] and [vars] replace the ones of dst.
+func overlaySetting(dst *toml.Tree, t *toml.Tree) error {
+	for _, key := range t.Keys() {
+		switch key {
+		case "include":
+			// already merged by loadSettingTree.
+		case "rule":
+			dst.SetPath([]string{key}, append(getSubTreeArray(key, t), getSubTreeArray(key, dst)...))
+		case "asas":
+			dst.SetPath([]string{key}, append(getSubTreeArray(key, dst), getSubTreeArray(key, t)...))
+		case "template", "vars":
+			sub, ok := t.Get(key).(*toml.Tree)
+			if !ok {
+				return fmt.Errorf("%s must be written as [%s] section", key, key)
+			}
+			if dst.Has(key) {
+				if _, ok := dst.Get(key).(*toml.Tree); !ok {
+					return fmt.Errorf("%s must be written as [%s] section", key, key)
+				}
+			}
+			for _, name := range sub.Keys() {
+				path := []string{key, name}
+				dst.SetPath(path, sub.GetPath([]string{name}))
+			}
+		default:
+			dst.SetPath([]string{key}, t.Get(key))
+		}
+	}
+	return nil
+}
+
+// loadProjectSettingTree overlays the setting file next to the project file on config if it exists.
+// It returns the path of the project setting file, or an empty string if it is not used.
+func loadProjectSettingTree(config *toml.Tree, sf *settingFiles, path string, projectPath string) (string, error) {
+	pf := projectSettingFile(projectPath)
+	if pf == "" || strings.EqualFold(pf, path) || !exists(pf) {
+		return "", nil
+	}
+	pt, psf, err := loadSettingTreeWith(pf, &settingFiles{lenient: sf.lenient})
+	if err != nil {
+		return "", err
+	}
+	if err = overlaySetting(config, pt); err != nil {
+		return "", fmt.Errorf("%s: %w", pf, err)
+	}
+	sf.Files = append(sf.Files, psf.Files...)
+	sf.Patterns = append(sf.Patterns, psf.Patterns...)
+	return pf, nil
+}
+
+// IsSettingFile reports whether path is the setting file, the included file
+// or the file that will be included by the patterns.
+func (ss *setting) IsSettingFile(path string) bool {
+	if pf := projectSettingFile(ss.projectPath); pf != "" && strings.EqualFold(pf, path) {
+		// it may be created later.
+		return true
+	}
+	for _, f := range ss.Files {
+		if strings.EqualFold(f, path) {
+			return true
+		}
+	}
+	for _, pattern := range ss.IncludePatterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SettingDirs returns the folders that contain the setting file, the included files
+// or the project setting file.
+func (ss *setting) SettingDirs() []string {
+	var r []string
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if key := strings.ToLower(dir); !seen[key] {
+			seen[key] = true
+			r = append(r, dir)
+		}
+	}
+	for _, f := range ss.Files {
+		add(filepath.Dir(f))
+	}
+	if ss.projectDir != "" {
+		add(ss.projectDir)
+	}
+	for _, pattern := range ss.IncludePatterns {
+		if dir := filepath.Dir(pattern); !strings.ContainsAny(dir, "*?[") {
+			add(dir)
+		}
+	}
+	return r
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func writeTestSetting(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	settingFile := filepath.Join(dir, "setting.txt")
+	writeTestSetting(t, settingFile, `
+include = ['chars/*.toml', 'tools/voicepeak.toml']
+encoding = 'utf8'
+
+[[rule]]
+file = 'main_*.wav'
+layer = 1
+`)
+	writeTestSetting(t, filepath.Join(dir, "chars", "b.toml"), `
+[[rule]]
+extends = 'voicepeak'
+file = 'b_*.wav'
+layer = 3
+`)
+	writeTestSetting(t, filepath.Join(dir, "chars", "a.toml"), `
+include = 'common.txt'
+
+[[rule]]
+file = 'a_*.wav'
+layer = 2
+`)
+	writeTestSetting(t, filepath.Join(dir, "chars", "common.txt"), `
+[[rule]]
+file = 'common_*.wav'
+layer = 4
+`)
+	writeTestSetting(t, filepath.Join(dir, "tools", "voicepeak.toml"), `
+[template.voicepeak]
+encoding = 'utf8'
+
+[[asas]]
+exe = 'voicepeak.exe'
+`)
+	s, err := loadSetting(settingFile, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, r := range s.Rule {
+		files = append(files, r.File)
+	}
+	if got, want := strings.Join(files, ","), "main_*.wav,a_*.wav,common_*.wav,b_*.wav"; got != want {
+		t.Errorf("rules: want %q got %q", want, got)
+	}
+	if s.Rule[3].Encoding != "utf8" || s.Rule[3].index != 4 {
+		t.Errorf("template in the included file is not applied: %+v", s.Rule[3])
+	}
+	if len(s.Asas) != 1 || s.Asas[0].Exe != "voicepeak.exe" {
+		t.Errorf("asas: unexpected %+v", s.Asas)
+	}
+	if len(s.Files) != 5 || s.Files[0] != settingFile {
+		t.Errorf("files: unexpected %v", s.Files)
+	}
+	for i, test := range []struct {
+		Path string
+		Want bool
+	}{
+		{settingFile, true},
+		{filepath.Join(dir, "chars", "common.txt"), true},
+		{filepath.Join(dir, "chars", "new.toml"), true},
+		{filepath.Join(dir, "chars", "new.txt"), false},
+		{filepath.Join(dir, "other.txt"), false},
+	} {
+		if got := s.IsSettingFile(test.Path); got != test.Want {
+			t.Errorf("No.%d: IsSettingFile(%s) want %v got %v", i, test.Path, test.Want, got)
+		}
+	}
+}
+
+func TestIncludeError(t *testing.T) {
+	tests := []struct {
+		Files map[string]string
+		Err   string
+	}{
+		{map[string]string{
+			"setting.txt": `include = 'notfound.toml'`,
+		}, "included file"},
+		{map[string]string{
+			"setting.txt": `include = 'a.toml'`,
+			"a.toml":      `delta = 1.0`,
+		}, "a.toml: delta cannot be used in included files"},
+		{map[string]string{
+			"setting.txt": "include = 'a.toml'\n[template.x]\nlayer = 1",
+			"a.toml":      "[template.x]\nlayer = 2",
+		}, `a.toml: template "x" is already defined`},
+		{map[string]string{
+			"setting.txt": `include = 'a.toml'`,
+			"a.toml":      `include = 'setting.txt'`,
+		}, ""},
+	}
+	for i, test := range tests {
+		dir := t.TempDir()
+		for name, content := range test.Files {
+			writeTestSetting(t, filepath.Join(dir, name), content)
+		}
+		_, err := loadSetting(filepath.Join(dir, "setting.txt"), dir, "")
+		if test.Err == "" {
+			if err != nil {
+				t.Errorf("No.%d: unexpected error %v", i, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.Err) {
+			t.Errorf("No.%d: want %q got %v", i, test.Err, err)
+		}
+	}
+}
+
+func TestCheckIncludedFile(t *testing.T) {
+	dir := t.TempDir()
+	settingFile := filepath.Join(dir, "setting.txt")
+	writeTestSetting(t, settingFile, `include = 'chars/*.toml'`)
+	included := filepath.Join(dir, "chars", "a.toml")
+	writeTestSetting(t, included, `
+freshness = 5.0
+
+[[rule]]
+layr = 1
+`)
+	issues, err := checkSettingFile(settingFile, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`2:1: "freshness" は include で読み込むファイルには書けません`,
+		`5:1: [[rule]] セクションの不明なキー "layr" です`,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("want %d issues got %v", len(want), issues)
+	}
+	for i, issue := range issues {
+		if issue.File != included || issue.String() != want[i] {
+			t.Errorf("No.%d: want %s:%s got %s:%s", i, included, want[i], issue.File, issue.String())
+		}
+	}
+}
+
+func TestProjectSetting(t *testing.T) {
+	dir := t.TempDir()
+	settingFile := filepath.Join(dir, "setting.txt")
+	writeTestSetting(t, settingFile, `
+delta = 10.0
+freshness = 3.0
+
+[vars]
+CHARS = 'global'
+
+[template.voice]
+layer = 1
+
+[[rule]]
+file = 'global_*.wav'
+extends = 'voice'
+`)
+	projectPath := filepath.Join(dir, "series", "01.aup")
+	projectSetting := filepath.Join(dir, "series", "setting.txt")
+	writeTestSetting(t, projectSetting, `
+delta = 20.0
+
+[vars]
+CHARS = 'series'
+
+[template.voice]
+layer = 5
+
+[[rule]]
+file = 'series_*.wav'
+extends = 'voice'
+`)
+	s, err := loadSetting(settingFile, dir, projectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Delta != 20.0 || s.Freshness != 3.0 {
+		t.Errorf("want delta 20 freshness 3 got %v %v", s.Delta, s.Freshness)
+	}
+	if v := s.dirReplacer.Replace("%CHARS%"); v != "series" {
+		t.Errorf("vars: want series got %q", v)
+	}
+	var rules []string
+	for _, r := range s.Rule {
+		rules = append(rules, fmt.Sprint(r.File, ":", r.Layer))
+	}
+	if got, want := strings.Join(rules, ","), "series_*.wav:5,global_*.wav:5"; got != want {
+		t.Errorf("rules: want %q got %q", want, got)
+	}
+	if s.ProjectFile != projectSetting || !s.IsSettingFile(projectSetting) {
+		t.Errorf("project file: unexpected %q", s.ProjectFile)
+	}
+
+	// the project setting file is watched even if it does not exist yet.
+	otherPath := filepath.Join(dir, "other", "01.aup")
+	if s, err = loadSetting(settingFile, dir, otherPath); err != nil {
+		t.Fatal(err)
+	}
+	if s.ProjectFile != "" || len(s.Rule) != 1 || s.Delta != 10.0 {
+		t.Errorf("project setting of the other project is applied: %+v", s)
+	}
+	if !s.IsSettingFile(filepath.Join(dir, "other", "setting.txt")) {
+		t.Errorf("setting.txt next to the project file is not a setting file")
+	}
+}
+
+func TestSyncSettingDirs(t *testing.T) {
+	base, project1, project2 := t.TempDir(), t.TempDir(), t.TempDir()
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	watched := map[string]string{}
+	tests := []struct {
+		dirs []string
+		want []string
+	}{
+		{[]string{base}, []string{base}},
+		{[]string{base, project1}, []string{base, project1}},
+		{[]string{base, project2}, []string{base, project2}},
+		{[]string{base, base}, []string{base}},
+	}
+	for idx, data := range tests {
+		syncSettingDirs(w, watched, data.dirs)
+		got := w.WatchList()
+		sort.Strings(got)
+		sort.Strings(data.want)
+		if strings.Join(got, "\n") != strings.Join(data.want, "\n") {
+			t.Errorf("No.%d: want %v got %v", idx, data.want, got)
+		}
+		if len(watched) != len(data.want) {
+			t.Errorf("No.%d: want %d watched folders got %v", idx, len(data.want), watched)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const journalRetention = 30 * 24 * time.Hour
+
+// journalEntry records what happened to a processed file.
+type journalEntry struct {
+	Src  string    `json:"src"`
+	Hash string    `json:"hash"`
+	Dest string    `json:"dest,omitempty"`
+	Rule int       `json:"rule"`
+	At   time.Time `json:"at"`
+}
+
+// journal is the persistent log of processed files.
+// It is used for long-term duplicate suppression and can be shown by the history command.
+//
+// The file is read again when it is changed by another process such as the forget command.
+type journal struct {
+	path string
+
+	mu      sync.Mutex
+	entries []journalEntry
+	byPath  map[string]int
+	// stamp is the state of the file when it was read or written last.
+	stamp polledFile
+}
+
+func openJournal(path string) (*journal, error) {
+	j := &journal{
+		path:   path,
+		byPath: map[string]int{},
+	}
+	expired, err := j.load()
+	if err != nil {
+		return nil, fmt.Errorf("処理履歴の読み込みに失敗しました: %w", err)
+	}
+	if expired {
+		if err = j.compact(); err != nil {
+			return nil, fmt.Errorf("処理履歴の整理に失敗しました: %w", err)
+		}
+	}
+	return j, nil
+}
+
+// load replaces the entries with the ones in the file.
+// expired reports whether the file has the entries to be removed by compact.
+func (j *journal) load() (expired bool, err error) {
+	j.entries = nil
+	j.byPath = map[string]int{}
+	f, err := os.Open(j.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			j.stamp = polledFile{}
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(-journalRetention)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for sc.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// ignore broken line such as the one written while power failure.
+			expired = true
+			continue
+		}
+		if e.At.Before(deadline) {
+			expired = true
+			continue
+		}
+		j.add(e)
+	}
+	if err = sc.Err(); err != nil {
+		return false, err
+	}
+	j.stamp = polledFile{Size: fi.Size(), ModTime: fi.ModTime()}
+	return expired, nil
+}
+
+// refresh reads the file again if it is changed since it was read or written last.
+func (j *journal) refresh() {
+	var stamp polledFile
+	if fi, err := os.Stat(j.path); err == nil {
+		stamp = polledFile{Size: fi.Size(), ModTime: fi.ModTime()}
+	}
+	if stamp == j.stamp {
+		return
+	}
+	if _, err := j.load(); err != nil {
+		log.Println(warn.Renderln("処理履歴の再読み込みに失敗しました:", err))
+	}
+}
+
+// updateStamp remembers the state of the file written by the journal itself.
+func (j *journal) updateStamp() {
+	if fi, err := os.Stat(j.path); err == nil {
+		j.stamp = polledFile{Size: fi.Size(), ModTime: fi.ModTime()}
+	}
+}
+
+func (j *journal) add(e journalEntry) {
+	j.entries = append(j.entries, e)
+	j.byPath[strings.ToLower(e.Src)] = len(j.entries) - 1
+	if e.Dest != "" {
+		j.byPath[strings.ToLower(e.Dest)] = len(j.entries) - 1
+	}
+}
+
+func (j *journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range j.entries {
+		if err = enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.updateStamp()
+	return nil
+}
+
+// Record appends the entry to the journal.
+// hash is the raw value returned by verifyAndCalcHash.
+func (j *journal) Record(src string, hash string, dest string, rule int, at time.Time) error {
+	e := journalEntry{
+		Src:  src,
+		Hash: hex.EncodeToString([]byte(hash)),
+		Dest: dest,
+		Rule: rule,
+		At:   at,
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// pick up the changes by the other process before appending.
+	j.refresh()
+	if err = os.MkdirAll(filepath.Dir(j.path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.updateStamp()
+	j.add(e)
+	return nil
+}
+
+// Processed reports whether the file at path with the hash has already been dropped.
+// path matches both the source and the destination of the entry.
+func (j *journal) Processed(path string, hash string) (*journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	idx, ok := j.byPath[strings.ToLower(path)]
+	if !ok {
+		return nil, false
+	}
+	e := j.entries[idx]
+	if e.Rule == 0 || e.Hash != hex.EncodeToString([]byte(hash)) {
+		return nil, false
+	}
+	return &e, true
+}
+
+// Handled reports whether the file at path with the hash has already been processed,
+// including the case where no rule matched.
+func (j *journal) Handled(path string, hash string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	idx, ok := j.byPath[strings.ToLower(path)]
+	return ok && j.entries[idx].Hash == hex.EncodeToString([]byte(hash))
+}
+
+// Forget removes the entries whose source or destination is name and returns the number of them.
+// name is compared with both the full path and the filename without the folder.
+// The removed files are no longer skipped when they are saved again.
+func (j *journal) Forget(name string) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refresh()
+	entries := j.entries
+	j.entries = nil
+	j.byPath = map[string]int{}
+	for _, e := range entries {
+		if matchJournalName(e.Src, name) || e.Dest != "" && matchJournalName(e.Dest, name) {
+			continue
+		}
+		j.add(e)
+	}
+	n := len(entries) - len(j.entries)
+	if n == 0 {
+		return 0, nil
+	}
+	if err := j.compact(); err != nil {
+		return 0, fmt.Errorf("処理履歴の保存に失敗しました: %w", err)
+	}
+	return n, nil
+}
+
+func matchJournalName(path string, name string) bool {
+	return strings.EqualFold(path, name) || strings.EqualFold(filepath.Base(path), name)
+}
+
+// Find returns the entries whose source or destination contains keyword.
+func (j *journal) Find(keyword string) []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keyword = strings.ToLower(keyword)
+	var r []journalEntry
+	for _, e := range j.entries {
+		if strings.Contains(strings.ToLower(e.Src), keyword) || strings.Contains(strings.ToLower(e.Dest), keyword) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
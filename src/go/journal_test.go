@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tmp", "journal.jsonl")
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := []struct {
+		Src  string
+		Hash string
+		Dest string
+		Rule int
+		At   time.Time
+	}{
+		{`C:\voice\1.wav`, "hash1", `C:\project\1.wav`, 1, now.Add(-journalRetention - time.Hour)},
+		{`C:\voice\2.wav`, "hash2", `C:\project\2.wav`, 2, now},
+		{`C:\voice\3.wav`, "hash3", "", 0, now},
+	}
+	for _, r := range records {
+		if err = j.Record(r.Src, r.Hash, r.Dest, r.Rule, r.At); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// append a broken line like the one written while power failure.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"src":`)
+	f.Close()
+
+	j, err = openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Path  string
+		Hash  string
+		Found bool
+	}{
+		{`C:\voice\1.wav`, "hash1", false},
+		{`C:\voice\2.wav`, "hash2", true},
+		{`c:\VOICE\2.wav`, "hash2", true},
+		{`C:\project\2.wav`, "hash2", true},
+		{`C:\voice\2.wav`, "modified", false},
+		{`C:\voice\3.wav`, "hash3", false},
+	}
+	for i, tt := range tests {
+		if _, found := j.Processed(tt.Path, tt.Hash); found != tt.Found {
+			t.Errorf("No.%d: want %v got %v", i, tt.Found, found)
+		}
+	}
+
+	if got := len(j.Find("")); got != 2 {
+		t.Errorf("want %d entries got %d", 2, got)
+	}
+	if got := j.Find("PROJECT"); len(got) != 1 || got[0].Rule != 2 {
+		t.Errorf("unexpected search result %+v", got)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("journal should be compacted to %d lines got %d", 2, n)
+	}
+}
+
+func TestJournalForget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tmp", "journal.jsonl")
+	running, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := []struct {
+		Src  string
+		Dest string
+	}{
+		{filepath.Join(dir, "voice", "1_a.wav"), filepath.Join(dir, "project", "1_a.wav")},
+		{filepath.Join(dir, "voice", "01_a.wav"), filepath.Join(dir, "project", "01_a.wav")},
+		{filepath.Join(dir, "voice", "2_b.wav"), filepath.Join(dir, "project", "renamed.wav")},
+	}
+	for _, r := range records {
+		if err = running.Record(r.Src, r.Src, r.Dest, 1, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		Name    string
+		Removed int
+		Kept    []int
+	}{
+		{"1_A.wav", 1, []int{1, 2}},
+		{"_a.wav", 0, []int{1, 2}},
+		{filepath.Join(dir, "project", "renamed.wav"), 1, []int{1}},
+	}
+	for idx, data := range tests {
+		// forget runs as another process.
+		j, err := openJournal(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := j.Forget(data.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != data.Removed {
+			t.Errorf("No.%d: want %d entries removed got %d", idx, data.Removed, n)
+		}
+		kept := map[int]bool{}
+		for _, i := range data.Kept {
+			kept[i] = true
+		}
+		for i, r := range records {
+			// the running watcher sees the change without restart.
+			if got := running.Handled(r.Src, r.Src); got != kept[i] {
+				t.Errorf("No.%d: %s: want handled %v got %v", idx, r.Src, kept[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// lastProjectName is the file under the tmp folder that holds the project information read last.
+const lastProjectName = "lastproject.json"
+
+// projectRecorder remembers the project information every time it is read from the wrapped dropper
+// so that the last known project can be used while AviUtl is not running.
+type projectRecorder struct {
+	d    dropper
+	path string
+}
+
+func newProjectRecorder(d dropper, path string) *projectRecorder {
+	return &projectRecorder{
+		d:    d,
+		path: path,
+	}
+}
+
+func (pr *projectRecorder) GCMZDropsData() (*gcmzDropsData, error) {
+	proj, err := pr.d.GCMZDropsData()
+	if err != nil || proj.Width == 0 {
+		return proj, err
+	}
+	if err := pr.save(proj); err != nil && verbose {
+		log.Println(suppress.Renderln("プロジェクト情報の保存に失敗しました:", err))
+	}
+	return proj, nil
+}
+
+func (pr *projectRecorder) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	return pr.d.SendFiles(window, layer, frameAdv, files)
+}
+
+// save writes proj to the file. The file is not touched if the content is not changed.
+func (pr *projectRecorder) save(proj *gcmzDropsData) error {
+	b, err := json.MarshalIndent(proj, "", "  ")
+	if err != nil {
+		return err
+	}
+	if old, err := os.ReadFile(pr.path); err == nil && string(old) == string(b) {
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(pr.path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(pr.path, b, 0666)
+}
+
+// LastProject returns the project information read last with Window = 0.
+// It returns os.ErrNotExist if no project has been read yet.
+func (pr *projectRecorder) LastProject() (*gcmzDropsData, error) {
+	b, err := os.ReadFile(pr.path)
+	if err != nil {
+		return nil, err
+	}
+	var proj gcmzDropsData
+	if err = json.Unmarshal(b, &proj); err != nil {
+		return nil, err
+	}
+	proj.Window = 0
+	return &proj, nil
+}
@@ -11,7 +11,6 @@ import (
 	"time"
 	"unicode/utf16"
 
-	"github.com/oov/audio/wave"
 	"github.com/yuin/gluare"
 	lua "github.com/yuin/gopher-lua"
 	"golang.org/x/text/encoding/japanese"
@@ -34,12 +33,27 @@ func luaDebugPrintVerbose(L *lua.LState) int {
 	return 0
 }
 
+// dryRunPaths maps the path that a file would have after moving or renaming to the actual path in dry-run mode.
+// A new one is created with each Lua state so that the mappings do not outlive a setting reload.
+type dryRunPaths map[string]string
+
+func (dp dryRunPaths) resolve(path string) string {
+	if p, ok := dp[path]; ok {
+		return p
+	}
+	return path
+}
+
 func luaExecute(path string, text string) lua.LGFunction {
 	return func(L *lua.LState) int {
 		nargs := L.GetTop()
 		if nargs == 0 {
 			return 0
 		}
+		if dryRun {
+			log.Println(info.Renderln("  [ドライラン] 外部コマンドの実行を省略しました:"), L.ToString(1))
+			return 0
+		}
 		tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("forcepser%d.wav", time.Now().UnixNano()))
 		defer os.Remove(tempFile)
 		replacer := strings.NewReplacer("%BEFORE%", path, "%AFTER%", tempFile)
@@ -97,6 +111,30 @@ func luaExecute(path string, text string) lua.LGFunction {
 	}
 }
 
+func luaSendFile(d dropper) lua.LGFunction {
+	return func(L *lua.LState) int {
+		window := L.ToInt(1)
+		layer := L.ToInt(2)
+		frameAdv := L.ToInt(3)
+		files := L.ToTable(4)
+
+		dir, err := os.Getwd()
+		if err != nil {
+			L.RaiseError("os.Getwd failed: %v", err)
+		}
+
+		n := files.MaxN()
+		paths := make([]string, 0, n)
+		for i := 1; i <= n; i++ {
+			paths = append(paths, filepath.Join(dir, files.RawGetInt(i).String()))
+		}
+		if err = d.SendFiles(uintptr(window), layer, frameAdv, paths); err != nil {
+			L.RaiseError("ごちゃまぜドロップスの外部連携API呼び出しに失敗しました: %v", err)
+		}
+		return 0
+	}
+}
+
 func luaReplaceEnv(ss *setting) lua.LGFunction {
 	return func(L *lua.LState) int {
 		path := L.ToString(1)
@@ -200,167 +238,320 @@ func findGoodFileName(candidate, dir string) (string, error) {
 	return candidate, fmt.Errorf("%s に似た名前のファイルが多すぎます", candidate)
 }
 
-func luaFindRule(ss *setting) lua.LGFunction {
+func luaFindRule(ss *setting, d dropper, dp dryRunPaths) lua.LGFunction {
 	return func(L *lua.LState) int {
-		path := L.ToString(1)
-		rule, text, err := ss.Find(path)
-		if err != nil {
-			L.RaiseError("マッチ条件の検索中にエラーが発生しました: %v", err)
+		results := findAndProcess(L, ss, d, dp, L.ToString(1), false)
+		if len(results) == 0 {
+			return 0
 		}
-		if rule == nil {
+		r := results[0]
+		L.Push(r.RawGetString("rule"))
+		L.Push(r.RawGetString("text"))
+		L.Push(r.RawGetString("path"))
+		return 3
+	}
+}
+
+// luaFindRules is like luaFindRule but returns all rules matched by continue = true
+// as an array of {rule = ..., text = ..., path = ...}.
+func luaFindRules(ss *setting, d dropper, dp dryRunPaths) lua.LGFunction {
+	return func(L *lua.LState) int {
+		results := findAndProcess(L, ss, d, dp, L.ToString(1), true)
+		if len(results) == 0 {
 			return 0
 		}
-		if rule.DeleteText {
-			textfile := changeExt(path, ".txt")
+		t := L.NewTable()
+		for _, r := range results {
+			t.Append(r)
+		}
+		L.Push(t)
+		return 1
+	}
+}
+
+// findAndProcess finds the rules for the file at path and processes the file for each of them.
+// Only the first matched rule is used unless all is true.
+func findAndProcess(L *lua.LState, ss *setting, d dropper, dp dryRunPaths, path string, all bool) []*lua.LTable {
+	ms, err := ss.FindAll(path)
+	if err != nil {
+		L.RaiseError("マッチ条件の検索中にエラーが発生しました: %v", err)
+	}
+	if len(ms) > 1 && !all {
+		ms = ms[:1]
+	}
+	var r []*lua.LTable
+	for i, m := range ms {
+		if i > 0 {
+			log.Println("  continue の設定に従い、ルール", m.Rule.index, "でも処理します")
+		}
+		var text string
+		var t *lua.LTable
+		t, text, path = processMatch(L, ss, d, dp, m, path, i == 0)
+		result := L.NewTable()
+		result.RawSetString("rule", t)
+		result.RawSetString("text", lua.LString(text))
+		result.RawSetString("path", lua.LString(path))
+		r = append(r, result)
+	}
+	return r
+}
+
+// processMatch deletes the text file, moves and renames the audio file at path according to the matched rule.
+// It returns the rule table, the text and the path of the audio file to be dropped.
+//
+// The rules matched after the first one handle the file that the previous rules will drop,
+// so they never delete the text file, copy the file instead of moving it and rename only the copied file.
+func processMatch(L *lua.LState, ss *setting, d dropper, dp dryRunPaths, m *match, path string, first bool) (*lua.LTable, string, string) {
+	var err error
+	rule, text := m.Rule, m.Text
+	if first && rule.DeleteText && m.TextPath != "" {
+		textfile := m.TextPath
+		if dryRun {
+			log.Println(info.Renderln("  [ドライラン] deletetext の設定による txt の削除を省略しました:"), textfile)
+		} else {
 			err = retry(func() error { return os.Remove(textfile) }, 3)
 			if err != nil {
 				L.RaiseError("%s が削除できません: %v", textfile, err)
 			}
 			log.Println("  deletetext の設定に従い txt を削除しました")
 		}
-		files, err := enumMoveTargetFiles(path)
-		if err != nil {
-			L.RaiseError("ファイルの列挙に失敗しました: %v", err)
+	}
+	files, err := enumMoveTargetFiles(dp.resolve(path))
+	if err != nil {
+		L.RaiseError("ファイルの列挙に失敗しました: %v", err)
+	}
+	if dryRun && rule.DeleteText && m.TextPath != "" {
+		// the text file would have been deleted.
+		for i, f := range files {
+			if f == filepath.Base(m.TextPath) {
+				files = append(files[:i], files[i+1:]...)
+				break
+			}
 		}
-		if rule.FileMove == "move" || rule.FileMove == "copy" {
-			destDir := rule.ExpandedDestDir()
-			srcDir := filepath.Dir(path)
-			if strings.Contains(rule.DestDir, "%PROJECTDIR%") && ss.projectDir == "" {
-				proj, err := readGCMZDropsData()
-				if err != nil || proj.GCMZAPIVer < 1 {
-					L.RaiseError("ごちゃまぜドロップス v0.3.13 以降を導入した AviUtl が見つかりません")
-				}
-				if proj.Width == 0 {
-					L.RaiseError("`AviUtl で編集中のプロジェクトファイルが見つかりません")
-				}
-				L.RaiseError("AviUtl のプロジェクトファイルがまだ保存されていないため処理を続行できません")
+	}
+	fileMove := rule.FileMove
+	if !first && fileMove == "move" {
+		fileMove = "copy"
+	}
+	copied := false
+	if fileMove == "move" || fileMove == "copy" {
+		srcDir := filepath.Dir(path)
+		projectPath := getProjectPath(d)
+		needsProject := strings.Contains(rule.DestDir, "%PROJECTDIR%") && ss.projectDir == "" ||
+			strings.Contains(rule.DestDir, "%PROJECTNAME%") && projectPath == ""
+		if needsProject {
+			proj, err := d.GCMZDropsData()
+			if err != nil || proj.GCMZAPIVer < 1 {
+				L.RaiseError("ごちゃまぜドロップス v0.3.13 以降を導入した AviUtl が見つかりません")
+			}
+			if proj.Width == 0 {
+				L.RaiseError("`AviUtl で編集中のプロジェクトファイルが見つかりません")
 			}
+			L.RaiseError("AviUtl のプロジェクトファイルがまだ保存されていないため処理を続行できません")
+		}
+		destDir := m.DestDir(projectPath, time.Now())
+		var same bool
+		switch {
+		case exists(destDir):
 			destfi, err := getFileInfo(destDir)
 			if err != nil {
-				L.RaiseError("%s先フォルダー %s の情報取得に失敗しました: %v", rule.FileMove.Readable(), destDir, err)
+				L.RaiseError("%s先フォルダー %s の情報取得に失敗しました: %v", fileMove.Readable(), destDir, err)
 			}
 			srcfi, err := getFileInfo(srcDir)
 			if err != nil {
-				L.RaiseError("%s元フォルダー %s の情報取得に失敗しました: %v", rule.FileMove.Readable(), srcDir, err)
+				L.RaiseError("%s元フォルダー %s の情報取得に失敗しました: %v", fileMove.Readable(), srcDir, err)
+			}
+			same = isSameFileInfo(destfi, srcfi)
+		case dryRun:
+			log.Println(info.Renderln("  [ドライラン] フォルダーの作成を省略しました:"), destDir)
+		default:
+			if err = os.MkdirAll(destDir, 0777); err != nil {
+				L.RaiseError("%s先フォルダー %s の作成に失敗しました: %v", fileMove.Readable(), destDir, err)
+			}
+			log.Println("  フォルダーを作成しました:", destDir)
+		}
+		if !same && dryRun {
+			for _, f := range files {
+				dp[filepath.Join(destDir, f)] = dp.resolve(filepath.Join(srcDir, f))
 			}
-			if !isSameFileInfo(destfi, srcfi) {
-				deleteFiles := []string{}
-				for _, f := range files {
-					oldpath := filepath.Join(srcDir, f)
-					newpath := filepath.Join(destDir, f)
-					err = retry(func() error { return copyFile(newpath, oldpath) }, 3)
-					if err != nil {
-						L.RaiseError("ファイルのコピーに失敗しました: %v", err)
-					}
-					if verbose {
-						log.Println(suppress.Renderln("ファイルコピー", oldpath, "->", newpath))
-					}
-					if rule.FileMove == "move" {
-						deleteFiles = append(deleteFiles, oldpath)
-					}
+			log.Printf(info.Renderln("  [ドライラン] filemove = \"%s\" の設定による%sを省略しました:")+"\n", fileMove, fileMove.Readable())
+			log.Println("    ", destDir)
+			path = filepath.Join(destDir, filepath.Base(path))
+			copied = true
+		} else if !same {
+			deleteFiles := []string{}
+			for _, f := range files {
+				oldpath := filepath.Join(srcDir, f)
+				newpath := filepath.Join(destDir, f)
+				err = retry(func() error { return copyFile(newpath, oldpath) }, 3)
+				if err != nil {
+					L.RaiseError("ファイルのコピーに失敗しました: %v", err)
+				}
+				if verbose {
+					log.Println(suppress.Renderln("ファイルコピー", oldpath, "->", newpath))
 				}
-				if rule.MoveDelay > 0 {
-					go delayRemove(deleteFiles, rule.MoveDelay)
-				} else {
-					delayRemove(deleteFiles, 0)
+				if fileMove == "move" {
+					deleteFiles = append(deleteFiles, oldpath)
 				}
-				log.Printf("  filemove = \"%s\" の設定に従い、ファイルを以下の場所に%sしました\n", rule.FileMove, rule.FileMove.Readable())
-				log.Println("    ", destDir)
-				path = filepath.Join(destDir, filepath.Base(path))
 			}
-		}
-		layer := rule.Layer
-		padding := lua.LValue(lua.LNumber(rule.Padding))
-		userdata := lua.LValue(lua.LString(rule.UserData))
-		exofile := lua.LValue(lua.LString(rule.ExoFile))
-		luafile := lua.LValue(lua.LString(rule.LuaFile))
-		if rule.Modifier != "" {
-			L2 := lua.NewState()
-			defer L2.Close()
-			L2.PreloadModule("re", gluare.Loader)
-			if err = L2.DoString(`re = require("re")`); err != nil {
-				L.RaiseError("modifier スクリプトの初期化中にエラーが発生しました: %v", err)
+			if rule.MoveDelay > 0 {
+				go delayRemove(deleteFiles, rule.MoveDelay)
+			} else {
+				delayRemove(deleteFiles, 0)
 			}
-			L2.SetGlobal("debug_print", L2.NewFunction(luaDebugPrint))
-			L2.SetGlobal("debug_error", L2.NewFunction(luaDebugError))
-			L2.SetGlobal("debug_print_verbose", L2.NewFunction(luaDebugPrintVerbose))
-			L2.SetGlobal("getaudioinfo", L2.NewFunction(luaGetAudioInfo))
-			L2.SetGlobal("execute", L2.NewFunction(luaExecute(path, text)))
-			L2.SetGlobal("tofilename", L2.NewFunction(luaToFilename))
-			L2.SetGlobal("layer", lua.LNumber(layer))
-			L2.SetGlobal("text", lua.LString(text))
-			filename := filepath.Base(path)
-			L2.SetGlobal("filename", lua.LString(filename))
-			L2.SetGlobal("wave", lua.LString(path))
-			L2.SetGlobal("padding", padding)
-			L2.SetGlobal("userdata", userdata)
-			L2.SetGlobal("exofile", exofile)
-			L2.SetGlobal("luafile", luafile)
-			if err = L2.DoString(rule.Modifier); err != nil {
-				L.RaiseError("modifier スクリプトの実行中にエラーが発生しました: %v", err)
+			log.Printf("  filemove = \"%s\" の設定に従い、ファイルを以下の場所に%sしました\n", fileMove, fileMove.Readable())
+			log.Println("    ", destDir)
+			path = filepath.Join(destDir, filepath.Base(path))
+			copied = true
+		}
+	}
+	mv := newModifierVars(m, path)
+	if rule.Modifier != "" {
+		filename := mv.Filename
+		if err = runModifier(m, path, mv, dp); err != nil {
+			L.RaiseError("%v", err)
+		}
+		text = mv.Text
+
+		if newfilename := mv.Filename; filename != newfilename && !first && !copied {
+			log.Println(warn.Renderln("  先に一致したルールで使用したファイルのため、ファイル名は変更しません"))
+		} else if filename != newfilename {
+			dir := filepath.Dir(path)
+			newfilename, err = findGoodFileName(newfilename, dir)
+			if err != nil {
+				L.RaiseError("ファイル名の候補が見つかりません: %v", err)
 			}
-			layer = int(lua.LVAsNumber(L2.GetGlobal("layer")))
-			text = L2.GetGlobal("text").String()
-			padding = L2.GetGlobal("padding")
-			userdata = L2.GetGlobal("userdata")
-			exofile = L2.GetGlobal("exofile")
-			luafile = L2.GetGlobal("luafile")
-
-			if newfilename := L2.GetGlobal("filename").String(); filename != newfilename {
-				dir := filepath.Dir(path)
-				newfilename, err = findGoodFileName(newfilename, dir)
+			for _, f := range files {
+				oldpath := filepath.Join(dir, f)
+				newpath := filepath.Join(dir, changeExt(newfilename, filepath.Ext(f)))
+				if dryRun {
+					dp[newpath] = dp.resolve(oldpath)
+					log.Println(info.Renderln("  [ドライラン] ファイル名の変更を省略しました:"), oldpath, "->", newpath)
+					continue
+				}
+				err = retry(func() error { return os.Rename(oldpath, newpath) }, 3)
 				if err != nil {
-					L.RaiseError("ファイル名の候補が見つかりません: %v", err)
+					L.RaiseError("ファイル名の変更に失敗しました: %v", err)
 				}
-				for _, f := range files {
-					oldpath := filepath.Join(dir, f)
-					newpath := filepath.Join(dir, changeExt(newfilename, filepath.Ext(f)))
-					err = retry(func() error { return os.Rename(oldpath, newpath) }, 3)
-					if err != nil {
-						L.RaiseError("ファイル名の変更に失敗しました: %v", err)
-					}
-					if verbose {
-						log.Println(suppress.Renderln("ファイル名変更:", oldpath, "->", newpath))
-					}
+				if verbose {
+					log.Println(suppress.Renderln("ファイル名変更:", oldpath, "->", newpath))
 				}
-				path = filepath.Join(dir, newfilename)
 			}
+			path = filepath.Join(dir, newfilename)
 		}
+	}
 
-		t := L.NewTable()
-		t.RawSetString("dir", lua.LString(rule.Dir))
-		t.RawSetString("file", lua.LString(rule.File))
-		t.RawSetString("encoding", lua.LString(rule.Encoding))
-		t.RawSetString("layer", lua.LNumber(layer))
-		t.RawSetString("text", lua.LString(rule.Text))
-		t.RawSetString("userdata", userdata)
-		t.RawSetString("padding", padding)
-		t.RawSetString("exofile", exofile)
-		t.RawSetString("luafile", luafile)
-		L.Push(t)
-		L.Push(lua.LString(text))
-		L.Push(lua.LString(path))
-		return 3
+	if dryRun {
+		log.Println(info.Renderln("  [ドライラン] ルール", rule.index, "に一致しました"))
+		log.Println(suppress.Renderln("    挿入先レイヤー:"), mv.Layer)
+		log.Println(suppress.Renderln("    テキスト:"), text)
+		log.Println(suppress.Renderln("    音声ファイル:"), path)
 	}
+
+	t := L.NewTable()
+	t.RawSetString("index", lua.LNumber(rule.index))
+	t.RawSetString("dir", lua.LString(rule.Dir))
+	t.RawSetString("subdir", lua.LString(m.SubDir))
+	t.RawSetString("file", lua.LString(rule.File))
+	t.RawSetString("encoding", lua.LString(rule.Encoding))
+	t.RawSetString("detectedencoding", lua.LString(m.Encoding))
+	t.RawSetString("layer", lua.LNumber(mv.Layer))
+	t.RawSetString("text", lua.LString(rule.Text))
+	t.RawSetString("userdata", mv.UserData)
+	t.RawSetString("padding", mv.Padding)
+	t.RawSetString("exofile", mv.ExoFile)
+	t.RawSetString("luafile", mv.LuaFile)
+	t.RawSetString("continue", lua.LBool(rule.Continue))
+	t.RawSetString("captures", capturesTable(L, m.Captures))
+	return t, text, path
 }
 
-func luaGetAudioInfo(L *lua.LState) int {
-	f, err := os.Open(L.ToString(1))
-	if err != nil {
-		L.RaiseError("ファイルが開けません: %v", err)
-	}
-	defer f.Close()
-	r, wfe, err := wave.NewLimitedReader(f)
-	if err != nil {
-		L.RaiseError("Wave ファイルの読み取りに失敗しました: %v", err)
+// modifierVars holds the variables that the modifier script can change.
+type modifierVars struct {
+	Layer    int
+	Text     string
+	Filename string
+	Padding  lua.LValue
+	UserData lua.LValue
+	ExoFile  lua.LValue
+	LuaFile  lua.LValue
+}
+
+// newModifierVars returns the initial values for the matched rule.
+// %CAP:name% in userdata, exofile and luafile is replaced with the captured string.
+// The captured string in exofile and luafile cannot contain path separators.
+func newModifierVars(m *match, path string) *modifierVars {
+	return &modifierVars{
+		Layer:    m.Layer,
+		Text:     m.Text,
+		Filename: filepath.Base(path),
+		Padding:  lua.LNumber(m.Rule.Padding),
+		UserData: lua.LString(m.expandCaptures(m.Rule.UserData)),
+		ExoFile:  lua.LString(m.expandPathCaptures(m.Rule.ExoFile)),
+		LuaFile:  lua.LString(m.expandPathCaptures(m.Rule.LuaFile)),
 	}
+}
+
+func capturesTable(L *lua.LState, captures map[string]string) *lua.LTable {
 	t := L.NewTable()
-	t.RawSetString("samplerate", lua.LNumber(wfe.Format.SamplesPerSec))
-	t.RawSetString("channels", lua.LNumber(wfe.Format.Channels))
-	t.RawSetString("bits", lua.LNumber(wfe.Format.BitsPerSample))
-	t.RawSetString("samples", lua.LNumber(r.N/int64(wfe.Format.Channels)/int64(wfe.Format.BitsPerSample/8)))
-	L.Push(t)
-	return 1
+	for k, v := range captures {
+		t.RawSetString(k, lua.LString(v))
+	}
+	return t
+}
+
+// runModifier executes the modifier script of the matched rule and updates mv.
+func runModifier(m *match, path string, mv *modifierVars, dp dryRunPaths) error {
+	L := lua.NewState()
+	defer L.Close()
+	L.PreloadModule("re", gluare.Loader)
+	if err := L.DoString(`re = require("re")`); err != nil {
+		return fmt.Errorf("modifier スクリプトの初期化中にエラーが発生しました: %w", err)
+	}
+	L.SetGlobal("debug_print", L.NewFunction(luaDebugPrint))
+	L.SetGlobal("debug_error", L.NewFunction(luaDebugError))
+	L.SetGlobal("debug_print_verbose", L.NewFunction(luaDebugPrintVerbose))
+	L.SetGlobal("getaudioinfo", L.NewFunction(luaGetAudioInfo(dp)))
+	L.SetGlobal("execute", L.NewFunction(luaExecute(path, mv.Text)))
+	L.SetGlobal("tofilename", L.NewFunction(luaToFilename))
+	L.SetGlobal("layer", lua.LNumber(mv.Layer))
+	L.SetGlobal("text", lua.LString(mv.Text))
+	L.SetGlobal("filename", lua.LString(mv.Filename))
+	L.SetGlobal("wave", lua.LString(path))
+	L.SetGlobal("subdir", lua.LString(m.SubDir))
+	L.SetGlobal("encoding", lua.LString(m.Encoding))
+	L.SetGlobal("captures", capturesTable(L, m.Captures))
+	L.SetGlobal("padding", mv.Padding)
+	L.SetGlobal("userdata", mv.UserData)
+	L.SetGlobal("exofile", mv.ExoFile)
+	L.SetGlobal("luafile", mv.LuaFile)
+	if err := L.DoString(m.Rule.Modifier); err != nil {
+		return fmt.Errorf("modifier スクリプトの実行中にエラーが発生しました: %w", err)
+	}
+	mv.Layer = int(lua.LVAsNumber(L.GetGlobal("layer")))
+	mv.Text = L.GetGlobal("text").String()
+	mv.Filename = L.GetGlobal("filename").String()
+	mv.Padding = L.GetGlobal("padding")
+	mv.UserData = L.GetGlobal("userdata")
+	mv.ExoFile = L.GetGlobal("exofile")
+	mv.LuaFile = L.GetGlobal("luafile")
+	return nil
+}
+
+func luaGetAudioInfo(dp dryRunPaths) lua.LGFunction {
+	return func(L *lua.LState) int {
+		ai, err := readAudioInfo(dp.resolve(L.ToString(1)))
+		if err != nil {
+			L.RaiseError("音声ファイルの読み取りに失敗しました: %v", err)
+		}
+		t := L.NewTable()
+		t.RawSetString("samplerate", lua.LNumber(ai.SampleRate))
+		t.RawSetString("channels", lua.LNumber(ai.Channels))
+		t.RawSetString("bits", lua.LNumber(ai.Bits))
+		t.RawSetString("samples", lua.LNumber(ai.Samples))
+		L.Push(t)
+		return 1
+	}
 }
 
 func luaFromSJIS(L *lua.LState) int {
@@ -381,18 +572,26 @@ func luaToSJIS(L *lua.LState) int {
 	return 1
 }
 
+// isInvalidFilenameChar reports whether c is removed by tofilename.
+func isInvalidFilenameChar(c rune) bool {
+	switch c {
+	case
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
+		0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
+		0x20, 0x22, 0x2a, 0x2f, 0x3a, 0x3c, 0x3e, 0x3f, 0x7c, 0x7f:
+		return true
+	}
+	return false
+}
+
 func luaToFilename(L *lua.LState) int {
 	var nc int
 	var rs []rune
 	n := int(L.ToNumber(2))
 	for _, c := range L.ToString(1) {
-		switch c {
-		case
-			0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
-			0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
-			0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
-			0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
-			0x20, 0x22, 0x2a, 0x2f, 0x3a, 0x3c, 0x3e, 0x3f, 0x7c, 0x7f:
+		if isInvalidFilenameChar(c) {
 			continue
 		}
 		nc++
@@ -7,26 +7,19 @@ import (
 	"fmt"
 	"hash/fnv"
 	"io"
+	"io/fs"
 	"log"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/oov/forcepser/fairy"
-	"github.com/oov/forcepser/fairy/aivoice2/v1"
-	"github.com/oov/forcepser/fairy/voicepeak/v2"
-	"github.com/oov/forcepser/fairy/voisonatalk/v1"
-	"github.com/oov/forcepser/hotkey"
-
 	"github.com/fsnotify/fsnotify"
 	"github.com/gookit/color"
-	"github.com/oov/audio/wave"
 	"github.com/yuin/gluare"
 	lua "github.com/yuin/gopher-lua"
-	"github.com/zzl/go-win32api/win32"
 )
 
 const maxRetry = 10
@@ -36,15 +29,18 @@ const writeNotificationDeadline = 5 * time.Second
 
 var verbose bool
 var preventClear bool
+var dryRun bool
 var version string
 
-var fairies = fairy.Fairies{aivoice2.New(), voicepeak.New(), voisonatalk.New()}
-
 type file struct {
 	Filepath string
 	Hash     string
 	ModDate  time.Time
 	TryCount int
+	// CTime is the creation time used by sort = 'ctime'.
+	CTime time.Time
+	// SortKey is the key used by sort = 'sortkey'.
+	SortKey string
 }
 
 type fileState struct {
@@ -86,36 +82,35 @@ var (
 	info     colorizer = color.Cyan
 )
 
-func clearScreen() error {
-	cmd := exec.Command("cmd", "/c", "cls")
-	cmd.Stdout = os.Stdout
-	return cmd.Run()
-}
-
-func verifyAndCalcHash(wavPath string, txtPath string, acceptEmptyText bool) (string, error) {
-	txt, err := os.OpenFile(txtPath, os.O_RDWR, 0666)
-	if err != nil {
-		return "", fmt.Errorf("テキストファイルが開けませんでした: %w", err)
-	}
-	defer txt.Close()
-	wav, err := os.OpenFile(wavPath, os.O_RDWR, 0666)
-	if err != nil {
-		return "", fmt.Errorf("waveファイルが開けませんでした: %w", err)
+// verifyAndCalcHash verifies the files are ready and returns the hash of them.
+// txtPath can be empty for rules that do not use a text file.
+func verifyAndCalcHash(audioPath string, txtPath string, acceptEmptyText bool) (string, error) {
+	var txt *os.File
+	if txtPath != "" {
+		var err error
+		txt, err = os.OpenFile(txtPath, os.O_RDWR, 0666)
+		if err != nil {
+			return "", fmt.Errorf("テキストファイルが開けませんでした: %w", err)
+		}
+		defer txt.Close()
 	}
-	defer wav.Close()
-	r, wfe, err := wave.NewLimitedReader(wav)
+	audio, err := os.OpenFile(audioPath, os.O_RDWR, 0666)
 	if err != nil {
-		return "", fmt.Errorf("waveファイルが読み取れませんでした: %w", err)
+		return "", fmt.Errorf("音声ファイルが開けませんでした: %w", err)
 	}
-	if r.N == 0 || wfe.Format.SamplesPerSec == 0 || wfe.Format.Channels == 0 || wfe.Format.BitsPerSample == 0 {
-		return "", fmt.Errorf("waveファイルに記録されている値が不正です: %w", err)
+	defer audio.Close()
+	if _, err = readAudioInfoFrom(audio); err != nil {
+		return "", fmt.Errorf("音声ファイルが読み取れませんでした: %w", err)
 	}
-	if _, err := wav.Seek(0, io.SeekStart); err != nil {
-		return "", fmt.Errorf("waveファイルの読み取りカーソルを移動できませんでした: %w", err)
+	if _, err := audio.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("音声ファイルの読み取りカーソルを移動できませんでした: %w", err)
 	}
 	h := fnv.New32a()
-	if _, err := io.Copy(h, wav); err != nil {
-		return "", fmt.Errorf("waveファイルが読み取れませんでした: %w", err)
+	if _, err := io.Copy(h, audio); err != nil {
+		return "", fmt.Errorf("音声ファイルが読み取れませんでした: %w", err)
+	}
+	if txt == nil {
+		return string(h.Sum(nil)), nil
 	}
 	h2 := fnv.New32a()
 	sz, err := io.Copy(h2, txt)
@@ -128,10 +123,15 @@ func verifyAndCalcHash(wavPath string, txtPath string, acceptEmptyText bool) (st
 	return string(h2.Sum(h.Sum(nil))), nil
 }
 
-func processFiles(L *lua.LState, files []file, sort string, recentChanged map[string]fileState, recentSent map[string]sentFileState) (needRetry bool, err error) {
+func processFiles(L *lua.LState, d dropper, files []file, sort string, sortFunc string, recentChanged map[string]fileState, recentSent map[string]sentFileState, j *journal) (needRetry bool, err error) {
 	var errStay error
 	defer func() {
-		for k, ct := range recentChanged {
+		for _, f := range files {
+			k := f.Filepath
+			ct, ok := recentChanged[k]
+			if !ok {
+				continue
+			}
 			if ct.Retry == maxRetry-1 || ct.Stay == maxStay-1 {
 				log.Println(warn.Renderln("  たくさん失敗したのでこのファイルは諦めます:", k))
 				delete(recentChanged, k)
@@ -147,7 +147,7 @@ func processFiles(L *lua.LState, files []file, sort string, recentChanged map[st
 		}
 	}()
 
-	proj, err := readGCMZDropsData()
+	proj, err := d.GCMZDropsData()
 	if err != nil {
 		if verbose {
 			log.Println(suppress.Renderln("プロジェクト情報取得失敗:", err))
@@ -159,6 +159,9 @@ func processFiles(L *lua.LState, files []file, sort string, recentChanged map[st
 		err = fmt.Errorf("AviUtl で編集中のプロジェクトが見つかりません")
 		return
 	}
+	if proj.Window == 0 {
+		log.Println(info.Renderln("AviUtl が見つからないため、最後に開いていたプロジェクトの情報で処理します"))
+	}
 	t := L.NewTable()
 	for _, f := range files {
 		file := L.NewTable()
@@ -167,6 +170,8 @@ func processFiles(L *lua.LState, files []file, sort string, recentChanged map[st
 		file.RawSetString("trycount", lua.LNumber(f.TryCount))
 		file.RawSetString("maxretry", lua.LNumber(maxRetry))
 		file.RawSetString("moddate", lua.LNumber(float64(f.ModDate.Unix())+(float64(f.ModDate.Nanosecond())/1e9)))
+		file.RawSetString("ctime", lua.LNumber(float64(f.CTime.Unix())+(float64(f.CTime.Nanosecond())/1e9)))
+		file.RawSetString("sortkey", lua.LString(f.SortKey))
 		t.Append(file)
 	}
 	pt := L.NewTable()
@@ -181,11 +186,15 @@ func processFiles(L *lua.LState, files []file, sort string, recentChanged map[st
 	pt.RawSetString("video_scale", lua.LNumber(proj.VideoScale))
 	pt.RawSetString("audio_rate", lua.LNumber(proj.AudioRate))
 	pt.RawSetString("audio_ch", lua.LNumber(proj.AudioCh))
+	var sf lua.LValue = lua.LNil
+	if sortFunc != "" {
+		sf = lua.LString(sortFuncSource(sortFunc))
+	}
 	if err = L.CallByParam(lua.P{
 		Fn:      L.GetGlobal("changed"),
 		NRet:    1,
 		Protect: true,
-	}, t, lua.LString(sort), pt); err != nil {
+	}, t, lua.LString(sort), pt, sf); err != nil {
 		return
 	}
 	rv := L.ToTable(-1)
@@ -210,26 +219,64 @@ func processFiles(L *lua.LState, files []file, sort string, recentChanged map[st
 			Hash: hash,
 		}
 
-		destV := tbl.RawGetString("dest")
-		if destV.Type() != lua.LTString {
-			continue // rule not found
+		dests, rules := successDests(tbl)
+		if len(dests) == 0 {
+			// rule not found
+			if dryRun {
+				continue
+			}
+			if err := j.Record(src, hash, "", 0, now); err != nil {
+				log.Println(warn.Renderln("  処理履歴の記録に失敗しました:", err))
+			}
+			continue
 		}
-		// if TTS software creates files in the same location as the project,
-		// files that have already been processed may be subject to processing again.
-		// put dest on recentSent to prevent it.
-		dest := destV.String()
-		recentSent[dest] = sentFileState{
-			At:   now,
-			Hash: hash,
+		for i, dest := range dests {
+			// if TTS software creates files in the same location as the project,
+			// files that have already been processed may be subject to processing again.
+			// put dest on recentSent to prevent it.
+			recentSent[dest] = sentFileState{
+				At:   now,
+				Hash: hash,
+			}
+			delete(recentChanged, dest)
+			if dryRun {
+				continue
+			}
+			if err := j.Record(src, hash, dest, rules[i], now); err != nil {
+				log.Println(warn.Renderln("  処理履歴の記録に失敗しました:", err))
+			}
 		}
-		delete(recentChanged, dest)
 	}
 	L.Pop(1)
 	return
 }
 
-func getProjectPath() string {
-	proj, err := readGCMZDropsData()
+// successDests returns the dropped files and the indices of their rules in the entry of the success table.
+// The entry has dests and rules arrays, or dest and rule for a single drop.
+func successDests(tbl *lua.LTable) ([]string, []int) {
+	if destV, ok := tbl.RawGetString("dest").(lua.LString); ok {
+		return []string{string(destV)}, []int{int(lua.LVAsNumber(tbl.RawGetString("rule")))}
+	}
+	destsV, ok := tbl.RawGetString("dests").(*lua.LTable)
+	if !ok {
+		return nil, nil
+	}
+	rulesV, _ := tbl.RawGetString("rules").(*lua.LTable)
+	var dests []string
+	var rules []int
+	for i := 1; i <= destsV.MaxN(); i++ {
+		dests = append(dests, destsV.RawGetInt(i).String())
+		rule := 0
+		if rulesV != nil {
+			rule = int(lua.LVAsNumber(rulesV.RawGetInt(i)))
+		}
+		rules = append(rules, rule)
+	}
+	return dests, rules
+}
+
+func getProjectPath(d dropper) string {
+	proj, err := d.GCMZDropsData()
 	if err != nil {
 		return ""
 	}
@@ -269,36 +316,13 @@ func getNamer(dir string) func(name, text string) (string, error) {
 	}
 }
 
-func watchFairyCall(ctx context.Context, notify chan<- map[string]struct{}, hk *hotkey.Hotkey, namer func(name, text string) (string, error)) {
-	for {
-		select {
-		case complete := <-hk.Notify:
-			if err := fairies.Execute(namer); err != nil {
-				if !errors.Is(err, fairy.ErrTargetNotFound) {
-					log.Println(warn.Renderln("  フェアリー: 処理を完遂できませんでした:", err))
-				} else {
-					log.Println(warn.Renderln("  フェアリー: アクティブなウィンドウがフェアリーコール対応アプリケーションではありません。"))
-					log.Println(info.Renderln("    機能説明:"), "https://oov.github.io/j/forcepser/fairycall/")
-					log.Println(suppress.Renderln("    フェアリーコール対応アプリケーション及び動作確認済みバージョン:"))
-					for _, f := range fairies {
-						log.Println(suppress.Renderln("     ", f.TestedProgram()))
-					}
-				}
-			}
-			complete()
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func watchProjectPath(ctx context.Context, notify chan<- map[string]struct{}, projectPath string) {
+func watchProjectPath(ctx context.Context, d dropper, notify chan<- map[string]struct{}, projectPath string) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(5 * time.Second):
-			if projectPath != getProjectPath() {
+			if projectPath != getProjectPath(d) {
 				if verbose {
 					log.Println(suppress.Renderln("  AviUtl のプロジェクトパスの変更を検出しました"))
 				}
@@ -309,79 +333,244 @@ func watchProjectPath(ctx context.Context, notify chan<- map[string]struct{}, pr
 	}
 }
 
-func watch(ctx context.Context, watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, notify chan<- map[string]struct{}, settingFile string, freshness float64, sortdelay float64) {
+// addSubDirs watches all subdirectories under dir and returns the added directories.
+func addSubDirs(watcher *fsnotify.Watcher, dir string) ([]string, error) {
+	var added []string
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() || path == dir {
+			return nil
+		}
+		if err = watcher.Add(path); err != nil {
+			return err
+		}
+		added = append(added, path)
+		return nil
+	})
+	return added, err
+}
+
+// syncSettingDirs makes settingWatcher watch dirs.
+// watched holds the currently watched folders keyed by the lower-cased path, and
+// the folders that are no longer used such as the folder of the previous project are removed from it.
+func syncSettingDirs(settingWatcher *fsnotify.Watcher, watched map[string]string, dirs []string) {
+	want := map[string]string{}
+	for _, dir := range dirs {
+		want[strings.ToLower(dir)] = dir
+	}
+	for key, dir := range watched {
+		if _, ok := want[key]; ok {
+			continue
+		}
+		if err := settingWatcher.Remove(dir); err != nil && verbose {
+			log.Println(suppress.Renderln("  設定ファイルフォルダーの監視の解除に失敗しました:", err))
+		}
+		delete(watched, key)
+	}
+	for key, dir := range want {
+		if _, ok := watched[key]; ok {
+			continue
+		}
+		if err := settingWatcher.Add(dir); err != nil {
+			log.Println(warn.Renderln("  [警告] 設定ファイルフォルダーの監視に失敗しました:", err))
+			continue
+		}
+		watched[key] = dir
+	}
+}
+
+// isUnderDirs reports whether path is one of dirs or is placed under them.
+func isUnderDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if len(path) < len(dir) || !strings.EqualFold(path[:len(dir)], dir) {
+			continue
+		}
+		if len(path) == len(dir) || os.IsPathSeparator(path[len(dir)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func watch(ctx context.Context, watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, notify chan<- map[string]struct{}, settingFile string, setting *setting) {
 	defer close(notify)
+	recursiveDirs := setting.RecursiveDirs()
+	pollDirs := setting.PollDirs()
+
 	var finish bool
+	var subDirs []string
+	defer func() {
+		for _, dir := range subDirs {
+			watcher.Remove(dir)
+		}
+	}()
+
+	// rescanner remembers the state of the directories watched by fsnotify to recover from overflow.
+	notifyDirs := map[string]bool{}
+	for _, dir := range setting.Dirs() {
+		if _, ok := pollDirs[dir]; !ok {
+			notifyDirs[dir] = isUnderDirs(dir, recursiveDirs)
+		}
+	}
+	exts := append(append([]string{}, setting.AudioExts()...), setting.TextExts()...)
+	// the batch markers are matched by name because they may not have an extension.
+	rescanner := newPoller(notifyDirs, exts, setting.IsBatchMarker)
+
+	polled := make(chan fsnotify.Event)
+	if len(pollDirs) > 0 {
+		go newPoller(pollDirs, exts, setting.IsBatchMarker).run(ctx, time.Duration(setting.PollInterval*float64(time.Second)), polled)
+	}
+
 	changed := map[string]struct{}{}
-	timer := time.NewTimer(time.Duration(sortdelay) * time.Second)
+	timer := time.NewTimer(time.Duration(setting.SortDelay) * time.Second)
 	timer.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case event := <-watcher.Events:
+	// timerAt is when the timer fires. The timer is only moved earlier so that
+	// the folder with the shortest sortdelay is not delayed by the other folders.
+	// process waits for the sortdelay of each folder again.
+	var timerAt time.Time
+	schedule := func(d time.Duration) {
+		at := time.Now().Add(d)
+		if timerAt.IsZero() || at.Before(timerAt) {
+			timerAt = at
+			timer.Reset(d)
+		}
+	}
+	sortDelay := func(path string) time.Duration {
+		return time.Duration(setting.DirOptions(filepath.Dir(path)).SortDelay * float64(time.Second))
+	}
+	handle := func(event fsnotify.Event) {
+		if verbose {
+			log.Println(suppress.Renderln("イベント検証:", event))
+		}
+		if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
 			if verbose {
-				log.Println(suppress.Renderln("イベント検証:", event))
+				log.Println(suppress.Renderln("  オペレーションが Create / Write ではないので何もしません"))
 			}
-			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
-				if verbose {
-					log.Println(suppress.Renderln("  オペレーションが Create / Write ではないので何もしません"))
+			return
+		}
+		if event.Op&fsnotify.Create == fsnotify.Create && isUnderDirs(filepath.Dir(event.Name), recursiveDirs) {
+			if st, err := os.Stat(event.Name); err == nil && st.IsDir() {
+				if err = watcher.Add(event.Name); err != nil {
+					log.Println(warn.Renderln("フォルダー", event.Name, "が監視できません:", err))
+					return
+				}
+				subDirs = append(subDirs, event.Name)
+				added, err := addSubDirs(watcher, event.Name)
+				subDirs = append(subDirs, added...)
+				if err != nil {
+					log.Println(warn.Renderln("フォルダー", event.Name, "のサブフォルダーが監視できません:", err))
 				}
-				continue
-			}
-			ext := strings.ToLower(filepath.Ext(event.Name))
-			if ext != ".wav" && ext != ".txt" {
 				if verbose {
-					log.Println(suppress.Renderln("  *.wav / *.txt のどちらでもないので何もしません"))
+					log.Println(suppress.Renderln("  作成されたフォルダーを監視対象に追加しました"))
 				}
-				continue
+				// files may have been written before the watch starts.
+				for _, dir := range append([]string{event.Name}, added...) {
+					entries, err := os.ReadDir(dir)
+					if err != nil {
+						continue
+					}
+					for _, e := range entries {
+						if !e.IsDir() && setting.IsAudioFile(e.Name()) {
+							path := filepath.Join(dir, e.Name())
+							changed[path] = struct{}{}
+							schedule(sortDelay(path))
+						}
+					}
+				}
+				return
 			}
-			st, err := os.Stat(event.Name)
-			if err != nil {
+		}
+		if setting.IsBatchMarker(event.Name) {
+			if verbose {
+				log.Println(suppress.Renderln("  バッチ処理の完了マーカーとして処理します"))
+			}
+			changed[event.Name] = struct{}{}
+			schedule(sortDelay(event.Name))
+			return
+		}
+		isAudio, isText := setting.IsAudioFile(event.Name), setting.IsTextFile(event.Name)
+		if !isAudio && !isText {
+			if verbose {
+				log.Println(suppress.Renderln("  音声ファイルとテキストファイルのどちらの拡張子でもないので何もしません"))
+			}
+			return
+		}
+		st, err := os.Stat(event.Name)
+		if err != nil {
+			if verbose {
+				log.Println(suppress.Renderln("  更新日時の取得に失敗したので何もしません"))
+			}
+			return
+		}
+		if event.Op&fsnotify.Write == fsnotify.Write {
+			// Even if freshness == 0, verify when notified by Write.
+			// Because Write notification is also sent in the case of a file attribute change or modify zone identifier.
+			// See: https://github.com/oov/forcepser/issues/10
+			if time.Since(st.ModTime()) > writeNotificationDeadline {
 				if verbose {
-					log.Println(suppress.Renderln("  更新日時の取得に失敗したので何もしません"))
+					log.Println(suppress.Renderln("  ファイル変更通知がありましたが更新日時が", writeNotificationDeadline, "以上前なので何もしません"))
 				}
-				continue
+				return
 			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				// Even if freshness == 0, verify when notified by Write.
-				// Because Write notification is also sent in the case of a file attribute change or modify zone identifier.
-				// See: https://github.com/oov/forcepser/issues/10
-				if time.Since(st.ModTime()) > writeNotificationDeadline {
+		} else {
+			if freshness := setting.DirOptions(filepath.Dir(event.Name)).Freshness; freshness > 0 {
+				if math.Abs(time.Since(st.ModTime()).Seconds()) > freshness {
 					if verbose {
-						log.Println(suppress.Renderln("  ファイル変更通知がありましたが更新日時が", writeNotificationDeadline, "以上前なので何もしません"))
-					}
-					continue
-				}
-			} else {
-				if freshness > 0 {
-					if math.Abs(time.Since(st.ModTime()).Seconds()) > freshness {
-						if verbose {
-							log.Println(suppress.Renderln("  更新日時が", freshness, "秒以上前なので何もしません"))
-						}
-						continue
+						log.Println(suppress.Renderln("  更新日時が", freshness, "秒以上前なので何もしません"))
 					}
+					return
 				}
 			}
-			if verbose {
-				log.Println(suppress.Renderln("  送信ファイル候補にします"))
+		}
+		audioFiles := []string{event.Name}
+		if !isAudio {
+			if audioFiles = setting.FindAudioFiles(event.Name); len(audioFiles) == 0 {
+				if verbose {
+					log.Println(suppress.Renderln("  対になる音声ファイルがまだないので何もしません"))
+				}
+				return
 			}
-			changed[event.Name[:len(event.Name)-len(ext)]+".wav"] = struct{}{}
-			timer.Reset(time.Duration(sortdelay) * time.Second)
+		}
+		if verbose {
+			log.Println(suppress.Renderln("  送信ファイル候補にします"))
+		}
+		for _, f := range audioFiles {
+			changed[f] = struct{}{}
+		}
+		schedule(sortDelay(event.Name))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-watcher.Events:
+			handle(event)
+		case event := <-polled:
+			handle(event)
 		case event := <-settingWatcher.Events:
-			if event.Name == settingFile {
+			if event.Name == settingFile || setting.IsSettingFile(event.Name) {
 				if verbose {
 					log.Println(suppress.Renderln("  設定ファイルの再読み込みとして処理します"))
 				}
 				finish = true
-				timer.Reset(100 * time.Millisecond)
+				schedule(100 * time.Millisecond)
 				continue
 			}
 		case err := <-watcher.Errors:
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Println(warn.Renderln("ファイル変更通知が多すぎて取りこぼしたため、フォルダーを再確認します"))
+				for _, event := range rescanner.scan() {
+					handle(event)
+				}
+				continue
+			}
 			log.Println(warn.Renderln("監視中にエラーが発生しました:", err))
 		case err := <-settingWatcher.Errors:
 			log.Println(warn.Renderln("監視中にエラーが発生しました:", err))
 		case <-timer.C:
+			timerAt = time.Time{}
 			if finish {
 				notify <- nil
 				continue
@@ -399,10 +588,70 @@ func bool2str(b bool, t string, f string) string {
 	return f
 }
 
-func printDetails(setting *setting, tempDir string) {
+func durationRange(min int, max int) string {
+	switch {
+	case max == 0:
+		return fmt.Sprintf("%d 以上", min)
+	case min == 0:
+		return fmt.Sprintf("%d 以下", max)
+	}
+	return fmt.Sprintf("%d から %d まで", min, max)
+}
+
+func textFromReadable(tf string) string {
+	switch tf {
+	case "file":
+		return "テキストファイル"
+	case "filename":
+		return "ファイル名"
+	case "metadata":
+		return "音声ファイルのメタデータ"
+	case "none":
+		return "なし"
+	}
+	return tf
+}
+
+func sortReadable(sort string) string {
+	switch sort {
+	case "moddate":
+		return "更新日時順"
+	case "name":
+		return "ファイル名順"
+	case "natural":
+		return "ファイル名の数字順"
+	case "ctime":
+		return "作成日時順"
+	case "sortkey":
+		return "sortkey 順"
+	case "lua":
+		return "sortfunc による順"
+	}
+	return sort
+}
+
+func printDetails(setting *setting, tempDir string, d dropper) {
 	var hasWarn bool
+	if setting.ProjectFile != "" {
+		log.Println(caption.Renderln("プロジェクトの設定ファイル:"))
+		log.Println("  " + setting.ProjectFile)
+		log.Println()
+	}
+	var included []string
+	for i, f := range setting.Files {
+		if i > 0 && f != setting.ProjectFile {
+			included = append(included, f)
+		}
+	}
+	if len(included) > 0 {
+		log.Println(caption.Renderln("include で読み込んだ設定ファイル:"))
+		for _, f := range included {
+			log.Println("  " + f)
+		}
+		log.Println()
+	}
 	log.Println(caption.Renderln("AviUtl プロジェクト情報:"))
-	proj, err := readGCMZDropsData()
+	proj, err := d.GCMZDropsData()
 	if err != nil {
 		log.Println(warn.Renderln("  ごちゃまぜドロップス v0.3 以降がインストールされた AviUtl が見つかりません"))
 	} else {
@@ -435,11 +684,29 @@ func printDetails(setting *setting, tempDir string) {
 	log.Println(suppress.Renderln("  %PROFILE%:   "), getSpecialFolderPath(CSIDL_PROFILE))
 	log.Println(suppress.Renderln("  %DESKTOP%:   "), getSpecialFolderPath(CSIDL_DESKTOP))
 	log.Println(suppress.Renderln("  %MYDOC%:     "), getSpecialFolderPath(CSIDL_PERSONAL))
+	for _, name := range setting.dirReplacer.UserVars() {
+		v, _ := setting.dirReplacer.lookup(name)
+		log.Println(suppress.Renderln("  %"+name+"%:"), v)
+	}
 	log.Println()
 
 	log.Println(suppress.Renderln("  処理対象になる更新日時の差(秒):"), setting.Delta)
 	log.Println(suppress.Renderln("  処理対象になるファイルの新しさ(秒):"), setting.Freshness)
 	log.Println(suppress.Renderln("  空のテキストファイルを受け入れる:"), bool2str(setting.AcceptEmptyText, "はい", "いいえ"))
+	log.Println(suppress.Renderln("  処理順:"), sortReadable(setting.Sort))
+	log.Println(suppress.Renderln("  起動時に取りこぼしを確認する範囲(秒):"), setting.CatchUp)
+	log.Println(suppress.Renderln("  フォルダーの監視方法:"), bool2str(setting.WatchMode == "poll", "ポーリング", "変更通知"))
+	if len(setting.PollDirs()) > 0 {
+		log.Println(suppress.Renderln("    ポーリング間隔(秒):"), setting.PollInterval)
+	}
+	log.Println(suppress.Renderln("  AviUtl が起動していない間のドロップを保留する:"), bool2str(setting.Offline, "はい", "いいえ"))
+	if od, ok := d.(*offlineDropper); ok {
+		log.Println(suppress.Renderln("    保留中のドロップ:"), od.Len(), "件")
+	}
+	if dd, ok := d.(*dryRunDropper); ok {
+		log.Println(suppress.Renderln("  ドライラン:"), "有効")
+		log.Println(suppress.Renderln("    生成したファイルの保存先:"), dd.rec.OutDir)
+	}
 	log.Println()
 
 	log.Println(caption.Renderln("フェアリーコール:"))
@@ -447,8 +714,8 @@ func printDetails(setting *setting, tempDir string) {
 	if setting.FairyCall != "" {
 		log.Println(suppress.Renderln("  呼び出しキー: "), setting.FairyCall)
 		log.Println(suppress.Renderln("  フェアリーコール対応アプリケーション及び動作確認済みバージョン:"))
-		for _, f := range fairies {
-			log.Println(suppress.Renderln("   "), f.TestedProgram())
+		for _, p := range testedFairyPrograms() {
+			log.Println(suppress.Renderln("   "), p)
 		}
 	} else {
 		log.Println(suppress.Renderln("  呼び出しキーの設定が行われていないため使用できません"))
@@ -470,27 +737,106 @@ func printDetails(setting *setting, tempDir string) {
 	log.Println()
 	for i, r := range setting.Rule {
 		log.Println(caption.Sprintf("ルール%d:", i+1))
-		log.Println(suppress.Renderln("  対象フォルダー:"), r.ExpandedDir())
-		log.Println(suppress.Renderln("  対象ファイル名:"), r.File)
-		log.Println(suppress.Renderln("  テキストファイルの文字コード:"), r.Encoding)
+		origin := func(key string) string {
+			if o, ok := r.origins[key]; ok {
+				return suppress.Renderln("(" + o + ")")
+			}
+			return ""
+		}
+		if len(r.Extends) > 0 {
+			log.Println(suppress.Renderln("  継承元テンプレート:"), strings.Join(r.Extends, " -> "))
+		}
+		log.Println(suppress.Renderln("  対象フォルダー:"), r.ExpandedDir(), origin("dir"))
+		if r.Recursive {
+			log.Println(suppress.Renderln("    サブフォルダーも対象にする:"), "はい", origin("recursive"))
+		}
+		if r.WatchMode != setting.WatchMode {
+			log.Println(suppress.Renderln("    監視方法:"), bool2str(r.WatchMode == "poll", "ポーリング", "変更通知"), origin("watchmode"))
+		}
+		log.Println(suppress.Renderln("  対象ファイル名:"), r.File, origin("file"))
+		if r.Project != "" {
+			log.Println(suppress.Renderln("  対象プロジェクト:"), r.Project, origin("project"))
+		} else if r.ProjectRE != "" {
+			log.Println(suppress.Renderln("  対象プロジェクトの正規表現:"), r.ProjectRE, origin("projectre"))
+		}
+		log.Println(suppress.Renderln("  音声ファイルの拡張子:"), strings.Join(r.AudioExt, ", "), origin("audioext"))
+		log.Println(suppress.Renderln("  テキストの取得元:"), textFromReadable(r.TextFrom), origin("textfrom"))
+		if r.TextFrom == "file" {
+			log.Println(suppress.Renderln("  テキストファイルの拡張子:"), strings.Join(r.TextExt, ", "), origin("textext"))
+		}
+		log.Println(suppress.Renderln("  テキストファイルの文字コード:"), r.Encoding, origin("encoding"))
 		if r.textRE != nil {
-			log.Println(suppress.Renderln("  テキスト判定用の正規表現:"), r.Text)
-		}
-		log.Println(suppress.Renderln("  挿入先レイヤー:"), r.Layer)
-		log.Println(suppress.Renderln("  modifier:"), bool2str(r.Modifier != "", "あり", "なし"))
-		log.Println(suppress.Renderln("  ユーザーデータ:"), r.UserData)
-		log.Println(suppress.Renderln("  パディング:"), r.Padding)
-		log.Println(suppress.Renderln("  EXOファイル:"), r.ExoFile)
-		log.Println(suppress.Renderln("  Luaファイル:"), r.LuaFile)
-		log.Println(suppress.Renderln("  Waveファイルの移動:"), r.FileMove.Readable())
+			log.Println(suppress.Renderln("  テキスト判定用の正規表現:"), r.Text, origin("text"))
+		}
+		if r.MinDuration > 0 || r.MaxDuration > 0 {
+			o := origin("minduration")
+			if o == "" {
+				o = origin("maxduration")
+			}
+			log.Println(suppress.Renderln("  音声ファイルの長さ(ミリ秒):"), durationRange(r.MinDuration, r.MaxDuration), o)
+		}
+		if r.SampleRate > 0 {
+			log.Println(suppress.Renderln("  サンプリングレート:"), r.SampleRate, origin("samplerate"))
+		}
+		if r.Channels > 0 {
+			log.Println(suppress.Renderln("  チャンネル数:"), r.Channels, origin("channels"))
+		}
+		if r.Bits > 0 {
+			log.Println(suppress.Renderln("  量子化ビット数:"), r.Bits, origin("bits"))
+		}
+		if r.LayerExpr != "" {
+			log.Println(suppress.Renderln("  挿入先レイヤー:"), r.LayerExpr, origin("layer"))
+		} else {
+			log.Println(suppress.Renderln("  挿入先レイヤー:"), r.Layer, origin("layer"))
+		}
+		if r.Continue {
+			log.Println(suppress.Renderln("  一致後も後ろのルールを検証する:"), "はい", origin("continue"))
+		}
+		log.Println(suppress.Renderln("  modifier:"), bool2str(r.Modifier != "", "あり", "なし"), origin("modifier"))
+		log.Println(suppress.Renderln("  ユーザーデータ:"), r.UserData, origin("userdata"))
+		log.Println(suppress.Renderln("  パディング:"), r.Padding, origin("padding"))
+		log.Println(suppress.Renderln("  EXOファイル:"), r.ExoFile, origin("exofile"))
+		log.Println(suppress.Renderln("  Luaファイル:"), r.LuaFile, origin("luafile"))
+		log.Println(suppress.Renderln("  Waveファイルの移動:"), r.FileMove.Readable(), origin("filemove"))
 		if r.FileMove != "off" {
-			log.Println(suppress.Sprintf("    %s先:", r.FileMove.Readable()), r.ExpandedDestDir())
+			log.Println(suppress.Sprintf("    %s先:", r.FileMove.Readable()), r.ExpandedDestDir(), origin("destdir"))
+		}
+		log.Println(suppress.Renderln("  テキストファイルの削除:"), bool2str(r.DeleteText, "する", "しない"), origin("deletetext"))
+		if r.CatchUp > 0 {
+			log.Println(suppress.Renderln("  起動時に取りこぼしを確認する範囲(秒):"), r.CatchUp, origin("catchup"))
+		}
+		if r.Delta != setting.Delta {
+			log.Println(suppress.Renderln("  処理対象になる更新日時の差(秒):"), r.Delta, origin("delta"))
+		}
+		if r.Freshness != setting.Freshness {
+			log.Println(suppress.Renderln("  処理対象になるファイルの新しさ(秒):"), r.Freshness, origin("freshness"))
+		}
+		if r.AcceptEmptyText != setting.AcceptEmptyText {
+			log.Println(suppress.Renderln("  空のテキストファイルを受け入れる:"), bool2str(r.AcceptEmptyText, "はい", "いいえ"), origin("acceptemptytext"))
+		}
+		if r.Sort != setting.Sort || r.SortDelay != setting.SortDelay || r.Sort == "sortkey" {
+			log.Println(suppress.Renderln("  処理順:"), sortReadable(r.Sort), origin("sort"))
+			if r.Sort == "sortkey" {
+				log.Println(suppress.Renderln("    sortkey:"), r.SortKey, origin("sortkey"))
+			}
+			log.Println(suppress.Renderln("  処理を始めるまでの待ち時間(秒):"), r.SortDelay, origin("sortdelay"))
+		}
+		if r.BatchMarker != "" {
+			log.Println(suppress.Renderln("  バッチ処理の完了マーカー:"), r.BatchMarker, origin("batchmarker"))
+		}
+		if r.BatchProcess != "" {
+			log.Println(suppress.Renderln("  処理が終わるのを待つプログラム:"), r.BatchProcess, origin("batchprocess"))
 		}
-		log.Println(suppress.Renderln("  テキストファイルの削除:"), bool2str(r.DeleteText, "する", "しない"))
 		if !r.ExistsDir() {
 			log.Println(warn.Renderln("  [警告] 対象フォルダー が見つからないため設定を無視します"))
 			hasWarn = true
 		}
+		for _, w := range setting.warnings {
+			if w.Rule == r.index {
+				log.Println(warn.Renderln("  [警告]", w.Message))
+				hasWarn = true
+			}
+		}
 	}
 	log.Println()
 	if hasWarn {
@@ -499,20 +845,70 @@ func printDetails(setting *setting, tempDir string) {
 	}
 }
 
-func loadSetting(path string, tempDir string, projectDir string) (*setting, error) {
-	f, err := os.Open(path)
+func loadSetting(path string, tempDir string, projectPath string) (*setting, error) {
+	config, sf, err := loadSettingTree(path)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := loadProjectSettingTree(config, sf, path, projectPath)
+	if err != nil {
+		return nil, err
+	}
+	var projectDir string
+	if projectPath != "" {
+		projectDir = filepath.Dir(projectPath)
+	}
+	s, err := newSettingFromTree(config, tempDir, projectDir)
 	if err != nil {
+		if pf != "" {
+			return nil, fmt.Errorf("with %s: %w", pf, err)
+		}
 		return nil, err
 	}
-	defer f.Close()
-	return newSetting(f, tempDir, projectDir)
+	s.Files = sf.Files
+	s.IncludePatterns = sf.Patterns
+	s.ProjectFile = pf
+	s.projectPath = projectPath
+	return s, nil
 }
 
 func tempSetting(tempDir string, projectDir string) (*setting, error) {
 	return newSetting(strings.NewReader(``), tempDir, projectDir)
 }
 
-func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settingFile string, recentChanged map[string]fileState, recentSent map[string]sentFileState, loop int) error {
+func newLuaState(setting *setting, d dropper, entrypoint string) (*lua.LState, error) {
+	L := lua.NewState()
+	L.PreloadModule("re", gluare.Loader)
+	err := L.DoString(`re = require("re")`)
+	if err != nil {
+		L.Close()
+		return nil, fmt.Errorf("スクリプト環境の初期化中にエラーが発生しました: %w", err)
+	}
+
+	L.SetGlobal("debug_print", L.NewFunction(luaDebugPrint))
+	L.SetGlobal("debug_error", L.NewFunction(luaDebugError))
+	L.SetGlobal("debug_print_verbose", L.NewFunction(luaDebugPrintVerbose))
+	L.SetGlobal("sendfile", L.NewFunction(luaSendFile(d)))
+	dp := dryRunPaths{}
+	L.SetGlobal("findrule", L.NewFunction(luaFindRule(setting, d, dp)))
+	L.SetGlobal("findrules", L.NewFunction(luaFindRules(setting, d, dp)))
+	L.SetGlobal("getaudioinfo", L.NewFunction(luaGetAudioInfo(dp)))
+	L.SetGlobal("tosjis", L.NewFunction(luaToSJIS))
+	L.SetGlobal("fromsjis", L.NewFunction(luaFromSJIS))
+	L.SetGlobal("toexostring", L.NewFunction(luaToEXOString))
+	L.SetGlobal("fromexostring", L.NewFunction(luaFromEXOString))
+	L.SetGlobal("tofilename", L.NewFunction(luaToFilename))
+	L.SetGlobal("replaceenv", L.NewFunction(luaReplaceEnv(setting)))
+	L.SetGlobal("naturalless", L.NewFunction(luaNaturalLess))
+
+	if err := L.DoFile(entrypoint); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("%s の実行中にエラーが発生しました: %w", filepath.Base(entrypoint), err)
+	}
+	return L, nil
+}
+
+func process(d dropper, watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settingDirs map[string]string, settingFile string, recentChanged map[string]fileState, recentSent map[string]sentFileState, caughtUp map[string]struct{}, j *journal, loop int) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("exe ファイルのパスが取得できません: %w", err)
@@ -522,13 +918,14 @@ func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settin
 		return fmt.Errorf("tmp フォルダの作成に失敗しました: %w", err)
 	}
 
-	projectPath := getProjectPath()
+	projectPath := getProjectPath(d)
 	var projectDir string
 	if projectPath != "" {
 		projectDir = filepath.Dir(projectPath)
 	}
 
-	setting, err := loadSetting(settingFile, tempDir, projectDir)
+	setting, err := loadSetting(settingFile, tempDir, projectPath)
+	loaded := err == nil
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return fmt.Errorf("設定の読み込みに失敗しました: %w", err)
@@ -537,35 +934,40 @@ func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settin
 		log.Println(suppress.Renderln(filepath.Base(settingFile), "を作成すると自動で読み込みます。"))
 		log.Println()
 		setting, _ = tempSetting(tempDir, projectDir)
-	} else {
-		printDetails(setting, tempDir)
 	}
 
-	L := lua.NewState()
-	defer L.Close()
-
-	L.PreloadModule("re", gluare.Loader)
-	err = L.DoString(`re = require("re")`)
-	if err != nil {
-		return fmt.Errorf("スクリプト環境の初期化中にエラーが発生しました: %w", err)
+	var od *offlineDropper
+	if setting.Offline && !dryRun {
+		rec, ok := d.(*projectRecorder)
+		if !ok {
+			rec = newProjectRecorder(d, filepath.Join(tempDir, lastProjectName))
+		}
+		od, err = newOfflineDropper(rec, filepath.Join(tempDir, "offline"))
+		if err != nil {
+			return err
+		}
+		d = od
+		if projectPath == "" {
+			// use the last known project while AviUtl is not running.
+			if projectPath = getProjectPath(d); projectPath != "" {
+				setting, err = loadSetting(settingFile, tempDir, projectPath)
+				if err != nil {
+					return fmt.Errorf("設定の読み込みに失敗しました: %w", err)
+				}
+			}
+		}
 	}
+	if loaded {
+		printDetails(setting, tempDir, d)
+	}
+	// the folder of the setting file is always watched to detect that it is created.
+	syncSettingDirs(settingWatcher, settingDirs, append([]string{filepath.Dir(settingFile)}, setting.SettingDirs()...))
 
-	L.SetGlobal("debug_print", L.NewFunction(luaDebugPrint))
-	L.SetGlobal("debug_error", L.NewFunction(luaDebugError))
-	L.SetGlobal("debug_print_verbose", L.NewFunction(luaDebugPrintVerbose))
-	L.SetGlobal("sendfile", L.NewFunction(luaSendFile))
-	L.SetGlobal("findrule", L.NewFunction(luaFindRule(setting)))
-	L.SetGlobal("getaudioinfo", L.NewFunction(luaGetAudioInfo))
-	L.SetGlobal("tosjis", L.NewFunction(luaToSJIS))
-	L.SetGlobal("fromsjis", L.NewFunction(luaFromSJIS))
-	L.SetGlobal("toexostring", L.NewFunction(luaToEXOString))
-	L.SetGlobal("fromexostring", L.NewFunction(luaFromEXOString))
-	L.SetGlobal("tofilename", L.NewFunction(luaToFilename))
-	L.SetGlobal("replaceenv", L.NewFunction(luaReplaceEnv(setting)))
-
-	if err := L.DoFile("_entrypoint.lua"); err != nil {
-		return fmt.Errorf("_entrypoint.lua の実行中にエラーが発生しました: %w", err)
+	L, err := newLuaState(setting, d, "_entrypoint.lua")
+	if err != nil {
+		return err
 	}
+	defer L.Close()
 
 	updateOnly := loop > 0
 	for _, a := range setting.Asas {
@@ -577,25 +979,25 @@ func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settin
 	}
 
 	log.Println(caption.Sprintf("監視を開始します:"))
-	var hk *hotkey.Hotkey
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	if setting.FairyCall != "" {
-		hk, err = hotkey.New(setting.FairyCall)
-		if err == nil {
-			defer hk.Close()
-		}
+		closeFairyCall, err := startFairyCall(ctx, setting.FairyCall, getNamer(tempDir))
 		if err != nil {
-			var msg string
-			if errors.Is(err, win32.ERROR_HOTKEY_ALREADY_REGISTERED) {
-				msg = "ショートカットキーが既に使用されています"
-			} else {
-				msg = err.Error()
-			}
-			log.Println(warn.Renderln("  [警告] フェアリーコール呼び出しキーの登録に失敗しました:", msg))
+			log.Println(warn.Renderln("  [警告] フェアリーコール呼び出しキーの登録に失敗しました:", err))
+		} else {
+			defer closeFairyCall()
 		}
 	}
 
 	watching := 0
+	pollDirs := setting.PollDirs()
 	for _, dir := range setting.Dirs() {
+		if _, ok := pollDirs[dir]; ok {
+			log.Println("  "+dir, suppress.Renderln("(ポーリング)"))
+			watching++
+			continue
+		}
 		err = watcher.Add(dir)
 		if err != nil {
 			return fmt.Errorf("フォルダー %s が監視できません: %w", dir, err)
@@ -604,20 +1006,174 @@ func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settin
 		watching++
 		defer watcher.Remove(dir)
 	}
+	for _, dir := range setting.RecursiveDirs() {
+		subDirs, err := addSubDirs(watcher, dir)
+		for _, sub := range subDirs {
+			defer watcher.Remove(sub)
+		}
+		if err != nil {
+			return fmt.Errorf("フォルダー %s のサブフォルダーが監視できません: %w", dir, err)
+		}
+		log.Println(suppress.Renderln("  サブフォルダー:", dir, "以下", len(subDirs), "個"))
+	}
 	if watching == 0 {
 		log.Println(warn.Renderln("  [警告] 監視対象のフォルダーがひとつもありません"))
 	}
 	notify := make(chan map[string]struct{}, 10000)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go watchProjectPath(ctx, notify, projectPath)
-	if hk != nil {
-		go watchFairyCall(ctx, notify, hk, getNamer(tempDir))
+	if found := catchUp(setting, newCatchUpDirs(setting.CatchUpDirs(), caughtUp), j); len(found) > 0 {
+		log.Println(info.Renderln("  監視していない間に作成されたファイルが見つかりました:"), len(found), "件")
+		notify <- found
+	}
+	go watchProjectPath(ctx, d, notify, projectPath)
+	if od != nil {
+		go watchOfflineQueue(ctx, od)
 	}
-	go watch(ctx, watcher, settingWatcher, notify, settingFile, setting.Freshness, setting.SortDelay)
+	go watch(ctx, watcher, settingWatcher, notify, settingFile, setting)
+	// pending holds when the candidates in each folder are verified.
+	// Each folder waits for its own sortdelay and its files are sorted separately.
+	pending := map[string]time.Time{}
 	timer := time.NewTimer(time.Duration(setting.SortDelay) * time.Second)
 	timer.Stop()
-	timerAt := time.Now()
+	reschedule := func() {
+		var next time.Time
+		for _, at := range pending {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+	delay := func(dir string, d time.Duration) {
+		if at := time.Now().Add(d); at.After(pending[dir]) {
+			pending[dir] = at
+		}
+		reschedule()
+	}
+	sortDelay := func(dir string) time.Duration {
+		return time.Duration(setting.DirOptions(dir).SortDelay * float64(time.Second))
+	}
+	waiter := newBatchWaiter()
+	// batchCompleted reports whether the batch export to dir is completed.
+	// It also returns the marker files to be deleted after the files are processed.
+	batchCompleted := func(dir string, opts dirOptions) (bool, []string) {
+		var markers []string
+		if opts.BatchMarker != "" {
+			var err error
+			if markers, err = setting.findBatchMarkers(dir); err != nil || len(markers) == 0 {
+				// the creation of the marker file will schedule dir again.
+				if verbose {
+					log.Println(suppress.Renderln("バッチ処理の完了マーカーを待っています:", dir))
+				}
+				return false, nil
+			}
+		}
+		if opts.BatchProcess != "" {
+			idle, err := waiter.Idle(opts.BatchProcess, time.Now())
+			if err != nil {
+				log.Println(warn.Renderln("  ", opts.BatchProcess, "の動作状況が確認できないため、処理を続行します:", err))
+			} else if !idle {
+				if verbose {
+					log.Println(suppress.Renderln(opts.BatchProcess, "の処理が終わるのを待っています:", dir))
+				}
+				d := sortDelay(dir)
+				if d < batchIdleInterval {
+					d = batchIdleInterval
+				}
+				delay(dir, d)
+				return false, nil
+			}
+		}
+		return true, markers
+	}
+	// the candidates may be left by the previous setting.
+	for wavPath := range recentChanged {
+		dir := filepath.Dir(wavPath)
+		delay(dir, sortDelay(dir))
+	}
+	// verify returns the files in dir that are ready to be processed.
+	// needRetry is true if some files are not ready yet.
+	verify := func(dir string, opts dirOptions) (files []file, needRetry bool) {
+		for wavPath, fState := range recentChanged {
+			if filepath.Dir(wavPath) != dir {
+				continue
+			}
+			if verbose {
+				log.Println(suppress.Renderln("送信ファイル候補検証:", wavPath))
+			}
+			if fState.Stay == maxStay {
+				log.Println(warn.Renderln("  以下のファイルは長時間準備が整わなかったので一旦諦めます"))
+				log.Println("    ", wavPath)
+				delete(recentChanged, wavPath)
+				continue
+			}
+
+			txtPath := setting.FindTextFile(wavPath)
+			s1, e1 := os.Stat(wavPath)
+			var s2 os.FileInfo
+			var e2 error
+			if txtPath != "" {
+				s2, e2 = os.Stat(txtPath)
+			}
+			if e1 != nil || e2 != nil || (txtPath == "" && !setting.AcceptsLoneAudio(wavPath)) {
+				// Whenever this issue is resolved, an Create/Write event will occur.
+				// So we ignore it for now.
+				if verbose {
+					log.Println(suppress.Renderln("  音声ファイルとテキストファイルが揃ってないので無視します"))
+				}
+				delete(recentChanged, wavPath)
+				continue
+			}
+			s1Mod := s1.ModTime()
+			if s2 != nil && opts.Delta > 0 {
+				s2Mod := s2.ModTime()
+				// Whenever this issue is resolved, an Write event will occur.
+				// So we ignore it for now.
+				if math.Abs(s1Mod.Sub(s2Mod).Seconds()) > opts.Delta {
+					if verbose {
+						log.Println(suppress.Renderln("  音声ファイルとテキストファイルの更新日時の差が", opts.Delta, "秒以上なので無視します"))
+					}
+					delete(recentChanged, wavPath)
+					continue
+				}
+			}
+			hash, err := verifyAndCalcHash(wavPath, txtPath, opts.AcceptEmptyText)
+			if err != nil {
+				if verbose {
+					log.Println(suppress.Renderln("  まだファイルの準備が整わないので保留にします"))
+					log.Println(suppress.Renderln("    理由:", err))
+				}
+				fState.Stay++
+				recentChanged[wavPath] = fState
+				needRetry = true
+			}
+			if st, found := recentSent[wavPath]; found && st.Hash == hash {
+				if verbose {
+					log.Println(suppress.Renderln("  つい最近送ったファイルなので、重複送信回避のために無視します"))
+				}
+				delete(recentChanged, wavPath)
+				continue
+			}
+			if e, found := j.Processed(wavPath, hash); found {
+				// a deliberate re-save of the same voice is also skipped, so it is always reported with the way to process it again.
+				log.Println(warn.Renderln("  処理履歴に同じ内容のファイルがあるので、重複送信回避のために無視します:"), wavPath)
+				log.Println(suppress.Renderln("    処理日時:", e.At.Format("2006-01-02 15:04:05")))
+				log.Println(suppress.Renderln("    もう一度処理するには forcepser.exe forget", filepath.Base(wavPath), "で処理履歴から削除してから保存し直してください"))
+				delete(recentChanged, wavPath)
+				continue
+			}
+			if verbose {
+				log.Println(suppress.Renderln("このファイルはルール検索対象です"))
+			}
+			f := file{Filepath: wavPath, Hash: hash, ModDate: s1Mod, TryCount: fState.Retry, CTime: fileCreationTime(s1)}
+			if opts.Sort == "sortkey" {
+				f.SortKey = setting.FileSortKey(wavPath)
+			}
+			files = append(files, f)
+		}
+		return files, needRetry
+	}
 	for {
 		select {
 		case updatedFiles, ok := <-notify:
@@ -637,100 +1193,97 @@ func process(watcher *fsnotify.Watcher, settingWatcher *fsnotify.Watcher, settin
 				}
 			}
 			for k := range updatedFiles {
+				if setting.IsBatchMarker(k) {
+					dir := filepath.Dir(k)
+					delay(dir, sortDelay(dir))
+					continue
+				}
 				if _, ok := recentChanged[k]; !ok {
 					recentChanged[k] = fileState{}
 				}
-			}
-			d := time.Duration(setting.SortDelay) * time.Second
-			at := time.Now().Add(d)
-			if at.After(timerAt) {
-				timerAt = at
-				timer.Reset(d)
+				dir := filepath.Dir(k)
+				delay(dir, sortDelay(dir))
 			}
 		case <-timer.C:
-			var files []file
-			var needRetry bool
-			for wavPath, fState := range recentChanged {
-				if verbose {
-					log.Println(suppress.Renderln("送信ファイル候補検証:", wavPath))
+			now := time.Now()
+			var dirs []string
+			for dir, at := range pending {
+				if !at.After(now) {
+					dirs = append(dirs, dir)
+					delete(pending, dir)
 				}
-				if fState.Stay == maxStay {
-					log.Println(warn.Renderln("  以下のファイルは長時間準備が整わなかったので一旦諦めます"))
-					log.Println("    ", wavPath)
-					delete(recentChanged, wavPath)
+			}
+			sort.Strings(dirs)
+			for _, dir := range dirs {
+				opts := setting.DirOptions(dir)
+				completed, markers := batchCompleted(dir, opts)
+				if !completed {
 					continue
 				}
-
-				txtPath := changeExt(wavPath, ".txt")
-				s1, e1 := os.Stat(wavPath)
-				s2, e2 := os.Stat(txtPath)
-				if e1 != nil || e2 != nil {
-					// Whenever this issue is resolved, an Create/Write event will occur.
-					// So we ignore it for now.
-					if verbose {
-						log.Println(suppress.Renderln("  *.wav と *.txt が揃ってないので無視します"))
-					}
-					delete(recentChanged, wavPath)
+				files, needRetry := verify(dir, opts)
+				if needRetry {
+					delay(dir, 500*time.Millisecond)
 					continue
 				}
-				s1Mod := s1.ModTime()
-				s2Mod := s2.ModTime()
-				if setting.Delta > 0 {
-					// Whenever this issue is resolved, an Write event will occur.
-					// So we ignore it for now.
-					if math.Abs(s1Mod.Sub(s2Mod).Seconds()) > setting.Delta {
-						if verbose {
-							log.Println(suppress.Renderln("  *.wav と *.txt の更新日時の差が", setting.Delta, "秒以上なので無視します"))
-						}
-						delete(recentChanged, wavPath)
+				if len(files) > 0 {
+					needRetry, err = processFiles(L, d, files, opts.Sort, opts.SortFunc, recentChanged, recentSent, j)
+					if err != nil {
+						log.Println("ファイルの処理中にエラーが発生しました:", err)
+					}
+					if needRetry {
+						delay(dir, 500*time.Millisecond)
 						continue
 					}
 				}
-				hash, err := verifyAndCalcHash(wavPath, txtPath, setting.AcceptEmptyText)
-				if err != nil {
-					if verbose {
-						log.Println(suppress.Renderln("  まだファイルの準備が整わないので保留にします"))
-						log.Println(suppress.Renderln("    理由:", err))
+				for _, marker := range markers {
+					if dryRun {
+						log.Println(info.Renderln("  [ドライラン] 完了マーカーの削除を省略しました:"), marker)
+						continue
 					}
-					fState.Stay++
-					recentChanged[wavPath] = fState
-					d := 500 * time.Millisecond
-					at := time.Now().Add(d)
-					if at.After(timerAt) {
-						timerAt = at
-						timer.Reset(d)
+					if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+						log.Println(warn.Renderln("  完了マーカーの削除に失敗しました:", err))
 					}
-					needRetry = true
-				}
-				if st, found := recentSent[wavPath]; found && st.Hash == hash {
-					if verbose {
-						log.Println(suppress.Renderln("  つい最近送ったファイルなので、重複送信回避のために無視します"))
-					}
-					delete(recentChanged, wavPath)
-					continue
-				}
-				if verbose {
-					log.Println(suppress.Renderln("このファイルはルール検索対象です"))
-				}
-				files = append(files, file{wavPath, hash, s1Mod, fState.Retry})
-			}
-			if needRetry || len(files) == 0 {
-				continue
-			}
-			needRetry, err = processFiles(L, files, setting.Sort, recentChanged, recentSent)
-			if err != nil {
-				log.Println("ファイルの処理中にエラーが発生しました:", err)
-			}
-			if needRetry {
-				d := 500 * time.Millisecond
-				at := time.Now().Add(d)
-				if at.After(timerAt) {
-					timerAt = at
-					timer.Reset(d)
 				}
 			}
+			reschedule()
+		}
+	}
+}
+
+func printHistory(j *journal, keyword string) {
+	entries := j.Find(keyword)
+	if len(entries) == 0 {
+		log.Println("処理履歴が見つかりませんでした")
+		return
+	}
+	for _, e := range entries {
+		if e.Rule == 0 {
+			log.Println(suppress.Renderln(e.At.Format("2006-01-02 15:04:05")), e.Src, warn.Renderln("(一致するルールなし)"))
+			continue
+		}
+		log.Println(suppress.Renderln(e.At.Format("2006-01-02 15:04:05")), e.Src, suppress.Renderln("->"), e.Dest, suppress.Renderln("(ルール", e.Rule, ")"))
+	}
+}
+
+func printCheck(settingFile string, tempDir string, d dropper) bool {
+	issues, err := checkSettingFile(settingFile, tempDir, getProjectPath(d))
+	if err != nil {
+		log.Println(warn.Renderln("設定の読み込みに失敗しました:"), err)
+		return false
+	}
+	if len(issues) == 0 {
+		log.Println(settingFile, info.Renderln("に問題は見つかりませんでした"))
+		return true
+	}
+	for _, issue := range issues {
+		file := issue.File
+		if file == "" {
+			file = settingFile
 		}
+		log.Println(warn.Renderln(file + ":" + issue.String()))
 	}
+	log.Println(len(issues), "件の問題が見つかりました")
+	return false
 }
 
 func main() {
@@ -741,15 +1294,21 @@ func main() {
 		}
 		return
 	}
-	if hr := win32.CoInitializeEx(nil, win32.COINIT_MULTITHREADED); win32.FAILED(hr) {
-		log.Fatalln("CoInitializeEx に失敗しました:", win32.HRESULT_ToString(hr))
+	cleanup, err := initPlatform()
+	if err != nil {
+		log.Fatalln(err)
 	}
-	defer win32.CoUninitialize()
+	defer cleanup()
 
 	var mono bool
+	var standInProject, standInDir, dryRunProject string
 	flag.BoolVar(&verbose, "v", false, "verbose output")
 	flag.BoolVar(&mono, "m", false, "disable color")
 	flag.BoolVar(&preventClear, "prevent-clear", false, "prevent clear screen on reload")
+	flag.StringVar(&standInProject, "standin-project", "", "read project information from JSON file instead of GCMZDrops")
+	flag.StringVar(&standInDir, "standin-dir", "", "record drops to this directory instead of sending to GCMZDrops")
+	flag.BoolVar(&dryRun, "dry-run", false, "evaluate rules and generate exo files without moving files and dropping")
+	flag.StringVar(&dryRunProject, "dry-run-project", "", "read project information from JSON file in dry-run mode")
 	flag.Parse()
 
 	if mono {
@@ -763,7 +1322,38 @@ func main() {
 		log.Fatalln("exe ファイルのパスが取得できません", err)
 	}
 
+	j, err := openJournal(filepath.Join(filepath.Dir(exePath), "tmp", "journal.jsonl"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if flag.Arg(0) == "history" {
+		printHistory(j, flag.Arg(1))
+		return
+	}
+	if flag.Arg(0) == "forget" {
+		if flag.Arg(1) == "" {
+			log.Println("使い方: forcepser.exe forget filename")
+			cleanup()
+			os.Exit(1)
+		}
+		n, err := j.Forget(flag.Arg(1))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("処理履歴から", n, "件を削除しました")
+		return
+	}
+
+	check := flag.Arg(0) == "check"
+	simulate := flag.Arg(0) == "simulate"
 	settingFile := flag.Arg(0)
+	switch {
+	case check:
+		settingFile = flag.Arg(1)
+	case simulate:
+		settingFile = ""
+	}
 	if settingFile == "" {
 		settingFile = filepath.Join(filepath.Dir(exePath), "setting.txt")
 	}
@@ -775,6 +1365,62 @@ func main() {
 		settingFile = p
 	}
 
+	var d dropper = gcmzDropper{}
+	lastProjectFile := filepath.Join(filepath.Dir(exePath), "tmp", lastProjectName)
+	if standInProject != "" || standInDir != "" {
+		if standInProject == "" || standInDir == "" {
+			log.Fatalln("-standin-project と -standin-dir は同時に指定してください")
+		}
+		standInProject, err = filepath.Abs(standInProject)
+		if err != nil {
+			log.Fatalln("filepath.Abs に失敗しました:", err)
+		}
+		standInDir, err = filepath.Abs(standInDir)
+		if err != nil {
+			log.Fatalln("filepath.Abs に失敗しました:", err)
+		}
+		d, err = newStandInDropper(standInProject, standInDir)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// keep the snapshot of the real project intact.
+		lastProjectFile = filepath.Join(standInDir, lastProjectName)
+	}
+	rec := newProjectRecorder(d, lastProjectFile)
+	d = rec
+
+	if simulate {
+		if !runSimulate(flag.Args()[1:], settingFile, filepath.Join(filepath.Dir(exePath), "tmp"), d) {
+			cleanup()
+			os.Exit(1)
+		}
+		return
+	}
+	if check {
+		if !printCheck(settingFile, filepath.Join(filepath.Dir(exePath), "tmp"), d) {
+			cleanup()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if dryRunProject != "" {
+		if !dryRun {
+			log.Fatalln("-dry-run-project は -dry-run と同時に指定してください")
+		}
+		dryRunProject, err = filepath.Abs(dryRunProject)
+		if err != nil {
+			log.Fatalln("filepath.Abs に失敗しました:", err)
+		}
+	}
+	if dryRun {
+		tempDir := filepath.Join(filepath.Dir(exePath), "tmp")
+		d, err = newDryRunDropper(rec, dryRunProject, filepath.Join(tempDir, "dryrun"))
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	if err := os.Chdir(filepath.Dir(exePath)); err != nil {
 		log.Fatalln("カレントディレクトリの変更に失敗しました:", err)
 	}
@@ -789,6 +1435,7 @@ func main() {
 	if err != nil {
 		log.Fatalln("設定ファイルフォルダーの監視に失敗しました:", err)
 	}
+	settingDirs := map[string]string{strings.ToLower(filepath.Dir(settingFile)): filepath.Dir(settingFile)}
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -798,14 +1445,19 @@ func main() {
 
 	recentChanged := map[string]fileState{}
 	recentSent := map[string]sentFileState{}
+	// caughtUp is the folders scanned by catchup so that reloads only scan the folders newly added.
+	caughtUp := map[string]struct{}{}
 	for i := 0; ; i++ {
 		log.Println(caption.Renderln("かんしくん"), version)
 		if verbose {
 			log.Println(warn.Renderln("冗長ログモードが有効"))
 		}
+		if dryRun {
+			log.Println(warn.Renderln("ドライランモードが有効（ファイルの移動や拡張編集へのドロップは行いません）"))
+		}
 		log.Println(suppress.Renderln("  設定ファイル:"), settingFile)
 		log.Println()
-		err = process(watcher, settingWatcher, settingFile, recentChanged, recentSent, i)
+		err = process(d, watcher, settingWatcher, settingDirs, settingFile, recentChanged, recentSent, caughtUp, j, i)
 		if err != nil {
 			log.Println(err)
 			log.Println("3秒後にリトライします")
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// offlineDropper keeps drops in a queue while AviUtl is not running.
+//
+// While the project is open, it behaves the same as the wrapped dropper.
+// Otherwise it returns the last known project information remembered by
+// projectRecorder with Window = 0, and SendFiles with Window = 0 puts
+// the drop into the queue persisted under dir.
+//
+// The queued drops are generated for the last known project, so they are only
+// delivered to the same project file.
+type offlineDropper struct {
+	d   *projectRecorder
+	dir string
+
+	mu    sync.Mutex
+	queue []offlineDrop
+	// warned is the project file that has already been warned about the drops kept for the other projects.
+	warned string
+}
+
+type offlineDrop struct {
+	Layer        int       `json:"layer"`
+	FrameAdvance int       `json:"frameadvance"`
+	Files        []string  `json:"files"`
+	ProjectFile  string    `json:"projectfile"`
+	At           time.Time `json:"at"`
+}
+
+func newOfflineDropper(d *projectRecorder, dir string) (*offlineDropper, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("オフラインキュー用フォルダーの作成に失敗しました: %w", err)
+	}
+	od := &offlineDropper{
+		d:   d,
+		dir: dir,
+	}
+	b, err := os.ReadFile(od.queueFile())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return od, nil
+		}
+		return nil, fmt.Errorf("オフラインキューの読み込みに失敗しました: %w", err)
+	}
+	if err = json.Unmarshal(b, &od.queue); err != nil {
+		return nil, fmt.Errorf("オフラインキューの読み込みに失敗しました: %w", err)
+	}
+	return od, nil
+}
+
+func (od *offlineDropper) queueFile() string {
+	return filepath.Join(od.dir, "queue.json")
+}
+
+func (od *offlineDropper) saveQueue() error {
+	b, err := json.MarshalIndent(od.queue, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(od.queueFile(), b, 0666)
+}
+
+// online returns the project information only if the project is open.
+func (od *offlineDropper) online() (*gcmzDropsData, error) {
+	proj, err := od.d.GCMZDropsData()
+	if err != nil {
+		return nil, err
+	}
+	if proj.Width == 0 {
+		return nil, fmt.Errorf("AviUtl で編集中のプロジェクトが見つかりません")
+	}
+	return proj, nil
+}
+
+func (od *offlineDropper) GCMZDropsData() (*gcmzDropsData, error) {
+	proj, err := od.online()
+	if err == nil {
+		return proj, nil
+	}
+	last, err2 := od.d.LastProject()
+	if err2 != nil {
+		if verbose {
+			log.Println(suppress.Renderln("最後に開いていたプロジェクトの情報が読み込めません:", err2))
+		}
+		return nil, err
+	}
+	return last, nil
+}
+
+func (od *offlineDropper) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	if window == 0 {
+		var projectFile string
+		if last, err := od.d.LastProject(); err == nil {
+			projectFile = last.ProjectFile
+		}
+		return od.push(layer, frameAdv, files, projectFile)
+	}
+	if len(od.queue) > 0 {
+		proj, err := od.online()
+		if err != nil {
+			return err
+		}
+		if err = od.flush(proj); err != nil {
+			// keep the order of drops.
+			log.Println(warn.Renderln("  " + err.Error()))
+			return od.push(layer, frameAdv, files, proj.ProjectFile)
+		}
+	}
+	return od.d.SendFiles(window, layer, frameAdv, files)
+}
+
+func (od *offlineDropper) push(layer int, frameAdv int, files []string, projectFile string) error {
+	now := time.Now()
+	drop := offlineDrop{
+		Layer:        layer,
+		FrameAdvance: frameAdv,
+		ProjectFile:  projectFile,
+		At:           now,
+	}
+	for i, f := range files {
+		c := filepath.Join(od.dir, fmt.Sprintf("%d_%d%s", now.UnixNano(), i+1, filepath.Ext(f)))
+		if err := copyFile(c, f); err != nil {
+			return fmt.Errorf("オフラインキューへの追加に失敗しました: %w", err)
+		}
+		drop.Files = append(drop.Files, c)
+	}
+	od.queue = append(od.queue, drop)
+	if err := od.saveQueue(); err != nil {
+		return fmt.Errorf("オフラインキューの保存に失敗しました: %w", err)
+	}
+	log.Println(info.Renderln("  AviUtl が見つからないため、ドロップを保留しました"), suppress.Renderln("(保留中:", len(od.queue), "件)"))
+	return nil
+}
+
+// flush delivers the queued drops for proj in the order they were queued.
+// The drops for the other projects are kept in the queue.
+func (od *offlineDropper) flush(proj *gcmzDropsData) error {
+	var n int
+	for _, drop := range od.queue {
+		if strings.EqualFold(drop.ProjectFile, proj.ProjectFile) {
+			n++
+		}
+	}
+	if other := len(od.queue) - n; other > 0 && !strings.EqualFold(od.warned, proj.ProjectFile) {
+		od.warned = proj.ProjectFile
+		log.Println(warn.Renderln("  [警告] 保留中のドロップ", other, "件は別のプロジェクト用に作成されたため送信しません"))
+		log.Println(suppress.Renderln("    保留した時のプロジェクトを開くと送信されます"))
+	}
+	if n == 0 {
+		return nil
+	}
+	log.Println(caption.Renderln("保留中のドロップを送信します:"), n, "件")
+	for i := 0; i < len(od.queue); {
+		drop := od.queue[i]
+		if !strings.EqualFold(drop.ProjectFile, proj.ProjectFile) {
+			i++
+			continue
+		}
+		if err := od.d.SendFiles(proj.Window, drop.Layer, drop.FrameAdvance, drop.Files); err != nil {
+			return fmt.Errorf("保留中のドロップの送信に失敗しました: %w", err)
+		}
+		log.Println(suppress.Renderln("  レイヤー", drop.Layer, "へドロップしました", drop.At.Format("(2006-01-02 15:04:05 に保留)")))
+		for _, f := range drop.Files {
+			if err := os.Remove(f); err != nil && verbose {
+				log.Println(suppress.Renderln("ファイル削除に失敗しました:", f, err))
+			}
+		}
+		od.queue = append(od.queue[:i], od.queue[i+1:]...)
+		if err := od.saveQueue(); err != nil {
+			return fmt.Errorf("オフラインキューの保存に失敗しました: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush delivers the queued drops if the project is open.
+func (od *offlineDropper) Flush() error {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	if len(od.queue) == 0 {
+		return nil
+	}
+	proj, err := od.online()
+	if err != nil {
+		return nil
+	}
+	return od.flush(proj)
+}
+
+func (od *offlineDropper) Len() int {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	return len(od.queue)
+}
+
+func watchOfflineQueue(ctx context.Context, od *offlineDropper) {
+	for {
+		if err := od.Flush(); err != nil {
+			log.Println(warn.Renderln(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
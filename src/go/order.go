@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// digitValue returns the value of c if c is a digit including full-width digits.
+func digitValue(c rune) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0'), true
+	case '０' <= c && c <= '９':
+		return int(c - '０'), true
+	}
+	return 0, false
+}
+
+// naturalLess reports whether a is less than b in natural order.
+// The digits in the strings including full-width digits are compared as numbers, so "2_" comes before "10_".
+func naturalLess(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		_, ad := digitValue(ar[i])
+		_, bd := digitValue(br[j])
+		if !ad || !bd {
+			if ar[i] != br[j] {
+				return ar[i] < br[j]
+			}
+			i++
+			j++
+			continue
+		}
+		// compare the numbers without leading zeros by the number of digits and then digit by digit.
+		ai, bi := i, j
+		for i < len(ar) {
+			if _, ok := digitValue(ar[i]); !ok {
+				break
+			}
+			i++
+		}
+		for j < len(br) {
+			if _, ok := digitValue(br[j]); !ok {
+				break
+			}
+			j++
+		}
+		an, bn := trimZeros(ar[ai:i]), trimZeros(br[bi:j])
+		if len(an) != len(bn) {
+			return len(an) < len(bn)
+		}
+		for k := range an {
+			av, _ := digitValue(an[k])
+			bv, _ := digitValue(bn[k])
+			if av != bv {
+				return av < bv
+			}
+		}
+	}
+	if len(ar)-i != len(br)-j {
+		return len(ar)-i < len(br)-j
+	}
+	// "1" and "01" are the same number.
+	return a < b
+}
+
+func trimZeros(digits []rune) []rune {
+	for len(digits) > 1 {
+		if v, _ := digitValue(digits[0]); v != 0 {
+			break
+		}
+		digits = digits[1:]
+	}
+	return digits
+}
+
+// compileSortFunc checks the syntax of sortfunc. The script is the body of function(a, b).
+func compileSortFunc(src string) error {
+	if _, err := parse.Parse(strings.NewReader(sortFuncSource(src)), "sortfunc"); err != nil {
+		return fmt.Errorf("sortfunc: %w", err)
+	}
+	return nil
+}
+
+// sortFuncSource wraps sortfunc to be loaded by loadstring in Lua.
+func sortFuncSource(src string) string {
+	return "local a, b = ...\n" + src
+}
+
+func luaNaturalLess(L *lua.LState) int {
+	L.Push(lua.LBool(naturalLess(L.CheckString(1), L.CheckString(2))))
+	return 1
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Want bool
+	}{
+		{"2_", "10_", true},
+		{"10_", "2_", false},
+		{"２_", "10_", true},
+		{"１０_", "９_", false},
+		{"a1b2", "a1b10", true},
+		{"a01", "a1", true},
+		{"a1", "a01", false},
+		{"a", "a1", true},
+		{"b", "a1", false},
+		{"a", "a", false},
+		{"007", "8", true},
+	}
+	for i, test := range tests {
+		if got := naturalLess(test.A, test.B); got != test.Want {
+			t.Errorf("No.%d: naturalLess(%q, %q) want %v got %v", i, test.A, test.B, test.Want, got)
+		}
+	}
+}
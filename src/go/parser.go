@@ -47,6 +47,22 @@ func getInt(key string, t *toml.Tree, def int) int {
 	return i
 }
 
+func getStringArray(key string, t *toml.Tree, def []string) []string {
+	v := t.Get(key)
+	if v == nil {
+		return def
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return []string{toString(v)}
+	}
+	r := make([]string, 0, len(a))
+	for _, vv := range a {
+		r = append(r, toString(vv))
+	}
+	return r
+}
+
 func getSubTreeArray(key string, t *toml.Tree) []*toml.Tree {
 	r, ok := t.Get(key).([]*toml.Tree)
 	if !ok {
@@ -0,0 +1,17 @@
+package main
+
+// Each platform provides the following functions:
+//
+//	getFileInfo / isSameFileInfo: identify the same file or directory regardless of path notation
+//	getSpecialFolderPath: resolve CSIDL_* to a path
+//	clearScreen: clear the console
+//	initPlatform: initialize the process wide resources and return the cleanup function
+//	startFairyCall / testedFairyPrograms: fairy call support
+//	processCPUTime: the CPU time used by the running processes of an executable
+//	fileCreationTime: the creation time of a file, or the modification time if it is not available
+
+const (
+	CSIDL_DESKTOP  = 0x00
+	CSIDL_PERSONAL = 0x05
+	CSIDL_PROFILE  = 0x28
+)
@@ -0,0 +1,61 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func getFileInfo(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func isSameFileInfo(fi1 os.FileInfo, fi2 os.FileInfo) bool {
+	return os.SameFile(fi1, fi2)
+}
+
+func getSpecialFolderPath(csidl uintptr) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	switch csidl {
+	case CSIDL_PROFILE:
+		return home
+	case CSIDL_DESKTOP:
+		return filepath.Join(home, "Desktop")
+	case CSIDL_PERSONAL:
+		return filepath.Join(home, "Documents")
+	}
+	return ""
+}
+
+func clearScreen() error {
+	_, err := fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J")
+	return err
+}
+
+func initPlatform() (func(), error) {
+	return func() {}, nil
+}
+
+func testedFairyPrograms() []string {
+	return nil
+}
+
+func startFairyCall(ctx context.Context, key string, namer func(name, text string) (string, error)) (func() error, error) {
+	return nil, errors.New("フェアリーコールはこのプラットフォームでは使用できません")
+}
+
+func processCPUTime(exe string) (time.Duration, bool, error) {
+	return 0, false, nil
+}
+
+func fileCreationTime(fi os.FileInfo) time.Time {
+	return fi.ModTime()
+}
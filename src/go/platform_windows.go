@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/oov/forcepser/fairy"
+	"github.com/oov/forcepser/fairy/aivoice2/v1"
+	"github.com/oov/forcepser/fairy/voicepeak/v2"
+	"github.com/oov/forcepser/fairy/voisonatalk/v1"
+	"github.com/oov/forcepser/hotkey"
+
+	"github.com/zzl/go-win32api/win32"
+	"golang.org/x/sys/windows"
+)
+
+var fairies = fairy.Fairies{aivoice2.New(), voicepeak.New(), voisonatalk.New()}
+
+func getFileInfo(path string) (*windows.ByHandleFileInformation, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	fa, err := windows.GetFileAttributes(name)
+	if err != nil {
+		return nil, err
+	}
+	attr := uint32(0)
+	if fa&windows.FILE_ATTRIBUTE_DIRECTORY == windows.FILE_ATTRIBUTE_DIRECTORY {
+		attr = windows.FILE_FLAG_BACKUP_SEMANTICS
+	}
+	h, err := windows.CreateFile(name, 0, windows.FILE_SHARE_DELETE|windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, attr, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	var fi windows.ByHandleFileInformation
+	if err = windows.GetFileInformationByHandle(h, &fi); err != nil {
+		return nil, err
+	}
+	return &fi, nil
+}
+
+func isSameFileInfo(fi1 *windows.ByHandleFileInformation, fi2 *windows.ByHandleFileInformation) bool {
+	return fi1.VolumeSerialNumber == fi2.VolumeSerialNumber && fi1.FileIndexLow == fi2.FileIndexLow && fi1.FileIndexHigh == fi2.FileIndexHigh
+}
+
+func getSpecialFolderPath(csidl uintptr) string {
+	var s [260]uint16
+	if !shGetSpecialFolderPath(getConsoleWindow(), &s[0], csidl, false) {
+		return ""
+	}
+	return windows.UTF16ToString(s[:])
+}
+
+func shGetSpecialFolderPath(hwnd windows.HWND, path *uint16, csidl uintptr, fCreate bool) bool {
+	var b uintptr
+	if fCreate {
+		b = 1
+	}
+	r0, _, _ := syscall.Syscall6(procSHGetSpecialFolderPath.Addr(), 4, uintptr(hwnd), uintptr(unsafe.Pointer(path)), csidl, b, 0, 0)
+	return r0 != 0
+}
+
+func clearScreen() error {
+	cmd := exec.Command("cmd", "/c", "cls")
+	cmd.Stdout = os.Stdout
+	return cmd.Run()
+}
+
+func initPlatform() (func(), error) {
+	if hr := win32.CoInitializeEx(nil, win32.COINIT_MULTITHREADED); win32.FAILED(hr) {
+		return nil, errors.New("CoInitializeEx に失敗しました: " + win32.HRESULT_ToString(hr))
+	}
+	return win32.CoUninitialize, nil
+}
+
+func testedFairyPrograms() []string {
+	r := make([]string, 0, len(fairies))
+	for _, f := range fairies {
+		r = append(r, f.TestedProgram())
+	}
+	return r
+}
+
+func startFairyCall(ctx context.Context, key string, namer func(name, text string) (string, error)) (func() error, error) {
+	hk, err := hotkey.New(key)
+	if err != nil {
+		if errors.Is(err, win32.ERROR_HOTKEY_ALREADY_REGISTERED) {
+			return nil, errors.New("ショートカットキーが既に使用されています")
+		}
+		return nil, err
+	}
+	go watchFairyCall(ctx, hk, namer)
+	return hk.Close, nil
+}
+
+func watchFairyCall(ctx context.Context, hk *hotkey.Hotkey, namer func(name, text string) (string, error)) {
+	for {
+		select {
+		case complete := <-hk.Notify:
+			if err := fairies.Execute(namer); err != nil {
+				if !errors.Is(err, fairy.ErrTargetNotFound) {
+					log.Println(warn.Renderln("  フェアリー: 処理を完遂できませんでした:", err))
+				} else {
+					log.Println(warn.Renderln("  フェアリー: アクティブなウィンドウがフェアリーコール対応アプリケーションではありません。"))
+					log.Println(info.Renderln("    機能説明:"), "https://oov.github.io/j/forcepser/fairycall/")
+					log.Println(suppress.Renderln("    フェアリーコール対応アプリケーション及び動作確認済みバージョン:"))
+					for _, p := range testedFairyPrograms() {
+						log.Println(suppress.Renderln("     ", p))
+					}
+				}
+			}
+			complete()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func processCPUTime(exe string) (time.Duration, bool, error) {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	defer windows.CloseHandle(snap)
+	var cpu time.Duration
+	var running bool
+	var pe windows.ProcessEntry32
+	pe.Size = uint32(unsafe.Sizeof(pe))
+	for err = windows.Process32First(snap, &pe); err == nil; err = windows.Process32Next(snap, &pe) {
+		if !strings.EqualFold(windows.UTF16ToString(pe.ExeFile[:]), exe) {
+			continue
+		}
+		running = true
+		h, oerr := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pe.ProcessID)
+		if oerr != nil {
+			continue
+		}
+		var creation, exit, kernel, user windows.Filetime
+		oerr = windows.GetProcessTimes(h, &creation, &exit, &kernel, &user)
+		windows.CloseHandle(h)
+		if oerr != nil {
+			continue
+		}
+		cpu += filetimeDuration(kernel) + filetimeDuration(user)
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return 0, false, err
+	}
+	return cpu, running, nil
+}
+
+func filetimeDuration(ft windows.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
+
+func fileCreationTime(fi os.FileInfo) time.Time {
+	if d, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, d.CreationTime.Nanoseconds())
+	}
+	return fi.ModTime()
+}
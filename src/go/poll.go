@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type polledFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// poller detects changes of the files with exts by listing directories periodically.
+// It is used where fsnotify cannot deliver events reliably such as network shares.
+type poller struct {
+	dirs map[string]bool // directory -> recursive
+	exts []string
+	// match reports whether the file that does not have exts is also watched. It may be nil.
+	match func(path string) bool
+	files map[string]polledFile
+}
+
+func newPoller(dirs map[string]bool, exts []string, match func(path string) bool) *poller {
+	p := &poller{
+		dirs:  dirs,
+		exts:  exts,
+		match: match,
+		files: map[string]polledFile{},
+	}
+	p.scan()
+	return p
+}
+
+func (p *poller) list() map[string]polledFile {
+	files := map[string]polledFile{}
+	for dir, recursive := range p.dirs {
+		filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+			if err != nil {
+				// the directory may be unreachable temporarily.
+				return nil
+			}
+			if e.IsDir() {
+				if path != dir && !recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !containsExt(p.exts, filepath.Ext(path)) && (p.match == nil || !p.match(path)) {
+				return nil
+			}
+			fi, err := e.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = polledFile{Size: fi.Size(), ModTime: fi.ModTime()}
+			return nil
+		})
+	}
+	return files
+}
+
+// scan lists the directories and returns the events synthesized from the differences since the last scan.
+func (p *poller) scan() []fsnotify.Event {
+	files := p.list()
+	var events []fsnotify.Event
+	for path, f := range files {
+		old, ok := p.files[path]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case old.Size != f.Size || !old.ModTime.Equal(f.ModTime):
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+	p.files = files
+	return events
+}
+
+func (p *poller) run(ctx context.Context, interval time.Duration, events chan<- fsnotify.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			for _, e := range p.scan() {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
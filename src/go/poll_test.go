@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPoller(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeTestText(t, filepath.Join(dir, "exists.txt"), "exists")
+
+	marker := func(path string) bool { return filepath.Base(path) == "DONE" }
+	flat := newPoller(map[string]bool{dir: false}, []string{".wav", ".txt"}, marker)
+	deep := newPoller(map[string]bool{dir: true}, []string{".wav", ".txt"}, nil)
+
+	writeTestText(t, filepath.Join(dir, "new.txt"), "new")
+	writeTestText(t, filepath.Join(dir, "exists.txt"), "modified")
+	writeTestText(t, filepath.Join(dir, "ignored.json"), "{}")
+	writeTestText(t, filepath.Join(dir, "DONE"), "")
+	writeTestText(t, filepath.Join(dir, "README"), "ignored")
+	writeTestText(t, filepath.Join(sub, "deep.txt"), "deep")
+	mt := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "exists.txt"), mt, mt); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		p    *poller
+		want []fsnotify.Event
+	}{
+		{flat, []fsnotify.Event{
+			{Name: filepath.Join(dir, "exists.txt"), Op: fsnotify.Write},
+			{Name: filepath.Join(dir, "DONE"), Op: fsnotify.Create},
+			{Name: filepath.Join(dir, "new.txt"), Op: fsnotify.Create},
+		}},
+		{deep, []fsnotify.Event{
+			{Name: filepath.Join(dir, "exists.txt"), Op: fsnotify.Write},
+			{Name: filepath.Join(dir, "new.txt"), Op: fsnotify.Create},
+			{Name: filepath.Join(sub, "deep.txt"), Op: fsnotify.Create},
+		}},
+	}
+	for idx, data := range tests {
+		got := data.p.scan()
+		sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+		sort.Slice(data.want, func(i, j int) bool { return data.want[i].Name < data.want[j].Name })
+		if len(got) != len(data.want) {
+			t.Errorf("No.%d: want %v got %v", idx, data.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != data.want[i] {
+				t.Errorf("No.%d: want %v got %v", idx, data.want[i], got[i])
+			}
+		}
+		if got = data.p.scan(); len(got) != 0 {
+			t.Errorf("No.%d: want no event on rescan got %v", idx, got)
+		}
+	}
+}
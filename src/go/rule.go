@@ -2,14 +2,18 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	toml "github.com/pelletier/go-toml"
 	"golang.org/x/text/encoding/japanese"
@@ -41,9 +45,11 @@ type rule struct {
 	FileRE   string
 	Encoding string
 	Layer    int
-	Modifier string
-	Text     string
-	UserData string
+	// LayerExpr is the layer written with %CAP:name%. Layer is not used if it is not empty.
+	LayerExpr string
+	Modifier  string
+	Text      string
+	UserData  string
 
 	ExoFile    string
 	LuaFile    string
@@ -52,10 +58,58 @@ type rule struct {
 	MoveDelay  float64
 	DeleteText bool
 	Padding    int
+	CatchUp    float64
+	Recursive  bool
+	WatchMode  string
+	AudioExt   []string
+	TextExt    []string
+	TextFrom   string
+	Extends    []string
+	// Continue makes the following rules also be evaluated after this rule matches.
+	Continue bool
+
+	// Freshness, Delta, Sort, SortDelay and AcceptEmptyText are applied to the folder of the rule.
+	// See DirOptions for the case that several rules watch the same folder.
+	Freshness       float64
+	Delta           float64
+	Sort            string
+	SortDelay       float64
+	AcceptEmptyText bool
+	// SortKey is the key for sort = 'sortkey'. It is usually written with %CAP:name%.
+	SortKey string
+	// SortFunc is the body of the Lua function(a, b) for sort = 'lua'.
+	SortFunc string
+	// BatchMarker is the wildcard of the file that tells the batch export is completed.
+	// BatchProcess is the executable that is waited for to become idle.
+	// The files in the folder are held until the batch is completed if either is set.
+	BatchMarker  string
+	BatchProcess string
+
+	// Project and ProjectRE restrict the rule to the AviUtl project being edited.
+	Project   string
+	ProjectRE string
 
-	fileRE      *regexp.Regexp
-	textRE      *regexp.Regexp
-	dirReplacer *strings.Replacer
+	// MinDuration and MaxDuration are the range of the audio length in milliseconds. 0 means no limit.
+	MinDuration int
+	MaxDuration int
+	// SampleRate, Channels and Bits must match the audio format if they are not 0.
+	SampleRate int
+	Channels   int
+	Bits       int
+
+	index         int
+	fileRE        *regexp.Regexp
+	textRE        *regexp.Regexp
+	projectRE     *regexp.Regexp
+	batchMarkerRE *regexp.Regexp
+	dirReplacer   *expander
+	// origins describes where the value of each key came from if it is not written in the rule.
+	origins map[string]string
+}
+
+// hasCapture reports whether filere or text has the named capture group.
+func (r *rule) hasCapture(name string) bool {
+	return r.fileRE.SubexpIndex(name) != -1 || r.textRE != nil && r.textRE.SubexpIndex(name) != -1
 }
 
 func (r *rule) ExpandedDir() string {
@@ -70,6 +124,17 @@ func (r *rule) ExistsDir() bool {
 	return exists(r.ExpandedDir())
 }
 
+// containsDir reports whether the files in dir are watched by the rule.
+// If recursive is true, the files in the subfolders of dir must also be watched.
+func (r *rule) containsDir(dir string, recursive bool) bool {
+	ruleDir := filepath.Clean(r.ExpandedDir())
+	dir = filepath.Clean(dir)
+	if strings.EqualFold(ruleDir, dir) {
+		return r.Recursive || !recursive
+	}
+	return r.Recursive && isUnderDirs(dir, []string{ruleDir})
+}
+
 type setting struct {
 	AcceptEmptyText bool
 	BaseDir         string
@@ -87,11 +152,65 @@ type setting struct {
 
 	Sort      string
 	SortDelay float64
+	SortKey   string
+	SortFunc  string
 
 	FairyCall string
 
+	Offline bool
+	CatchUp float64
+
+	WatchMode    string
+	PollInterval float64
+
+	AudioExt []string
+	TextExt  []string
+	TextFrom string
+
+	// Files is the setting file and the files included from it.
+	Files []string
+	// IncludePatterns is the patterns of include in absolute path.
+	IncludePatterns []string
+	// ProjectFile is the setting file placed next to the project file if it is loaded.
+	ProjectFile string
+
+	projectPath string
 	projectDir  string
-	dirReplacer *strings.Replacer
+	dirReplacer *expander
+	warnings    []ruleWarning
+}
+
+func getSort(t *toml.Tree, def string) (string, error) {
+	switch ss := getString("sort", t, def); ss {
+	case "moddate", "name", "natural", "ctime", "sortkey", "lua":
+		return ss, nil
+	default:
+		return "", fmt.Errorf("unknown sort: %s", ss)
+	}
+}
+
+func getTextFrom(t *toml.Tree, def string) string {
+	switch tf := getString("textfrom", t, def); tf {
+	case "file", "filename", "metadata", "none":
+		return tf
+	}
+	return def
+}
+
+// normalizeExts converts extensions like "WAV" to ".wav".
+func normalizeExts(exts []string) []string {
+	r := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		r = append(r, ext)
+	}
+	return r
 }
 
 func makeWildcard(s string) (*regexp.Regexp, error) {
@@ -120,22 +239,37 @@ func makeWildcard(s string) (*regexp.Regexp, error) {
 	return regexp.Compile(string(buf))
 }
 
+// makeProjectWildcard is makeWildcard for the project files.
+// It ignores the case because the project files are on Windows filesystems.
+func makeProjectWildcard(s string) (*regexp.Regexp, error) {
+	re, err := makeWildcard(s)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile("(?i)" + re.String())
+}
+
 func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error) {
 	config, err := loadTOML(r)
 	if err != nil {
 		return nil, fmt.Errorf("could not read setting file: %w", err)
 	}
+	return newSettingFromTree(config, tempDir, projectDir)
+}
+
+func newSettingFromTree(config *toml.Tree, tempDir string, projectDir string) (*setting, error) {
+	var err error
 	var s setting
 	s.projectDir = projectDir
 	s.BaseDir = getString("basedir", config, "")
-	s.dirReplacer = strings.NewReplacer(
-		"%BASEDIR%", s.BaseDir,
-		"%TEMPDIR%", tempDir,
-		"%PROJECTDIR%", s.projectDir,
-		"%PROFILE%", getSpecialFolderPath(CSIDL_PROFILE),
-		"%DESKTOP%", getSpecialFolderPath(CSIDL_DESKTOP),
-		"%MYDOC%", getSpecialFolderPath(CSIDL_PERSONAL),
-	)
+	vars, ok := config.Get("vars").(*toml.Tree)
+	if !ok && config.Has("vars") {
+		return nil, fmt.Errorf("vars must be written as [vars] section")
+	}
+	s.dirReplacer, err = newExpander(builtinVars(s.BaseDir, tempDir, s.projectDir), vars)
+	if err != nil {
+		return nil, err
+	}
 
 	s.Delta = getFloat64("delta", config, 15.0)
 	s.Freshness = getFloat64("freshness", config, 5.0)
@@ -154,25 +288,85 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 	s.AcceptEmptyText = getBool("acceptemptytext", config, false)
 	s.DeleteText = getBool("deletetext", config, false)
 
-	switch ss := getString("sort", config, "moddate"); ss {
-	case "moddate", "name":
-		s.Sort = ss
-	default:
-		s.Sort = "moddate"
+	if s.Sort, err = getSort(config, "moddate"); err != nil {
+		return nil, err
 	}
 	s.SortDelay = getFloat64("sortdelay", config, 0.1)
+	s.SortKey = getString("sortkey", config, "")
+	s.SortFunc = getString("sortfunc", config, "")
 
 	s.FairyCall = getString("fairycall", config, "")
 
+	s.Offline = getBool("offline", config, false)
+	s.CatchUp = getFloat64("catchup", config, 0)
+
+	switch wm := getString("watchmode", config, "notify"); wm {
+	case "notify", "poll":
+		s.WatchMode = wm
+	default:
+		s.WatchMode = "notify"
+	}
+	s.PollInterval = getFloat64("pollinterval", config, 2.0)
+	if s.PollInterval <= 0 {
+		s.PollInterval = 2.0
+	}
+
+	s.AudioExt = normalizeExts(getStringArray("audioext", config, []string{".wav"}))
+	s.TextExt = normalizeExts(getStringArray("textext", config, []string{".txt"}))
+	if len(s.AudioExt) == 0 || len(s.TextExt) == 0 {
+		return nil, fmt.Errorf("audioext and textext must not be empty")
+	}
+	s.TextFrom = getTextFrom(config, "file")
+
+	templates, err := getTemplates(config)
+	if err != nil {
+		return nil, err
+	}
 	for _, tr := range getSubTreeArray("rule", config) {
 		var r rule
+		r.index = len(s.Rule) + 1
 		r.dirReplacer = s.dirReplacer
 
+		var origins map[string]string
+		tr, r.Extends, origins, err = inheritTemplates(tr, templates)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", r.index, err)
+		}
+		r.origins = map[string]string{}
+		for key := range ruleKeys {
+			if name, ok := origins[key]; ok {
+				r.origins[key] = fmt.Sprintf("テンプレート %q", name)
+			} else if _, ok := settingKeys[key]; ok && !tr.Has(key) && config.Has(key) {
+				r.origins[key] = "グローバル設定"
+			}
+		}
+
 		r.Dir = getString("dir", tr, "%TEMPDIR%")
+		r.Recursive = getBool("recursive", tr, false)
+		switch wm := getString("watchmode", tr, s.WatchMode); wm {
+		case "notify", "poll":
+			r.WatchMode = wm
+		default:
+			r.WatchMode = s.WatchMode
+		}
 
 		r.Encoding = getString("encoding", tr, "sjis")
+		if _, ok := encodingNames[r.Encoding]; !ok && r.Encoding != "auto" {
+			return nil, fmt.Errorf("unknown encoding: %s", r.Encoding)
+		}
+
+		if layer, ok := tr.Get("layer").(string); ok && len(capNames(layer)) > 0 {
+			r.LayerExpr = layer
+		} else {
+			r.Layer = getInt("layer", tr, 1)
+		}
 
-		r.Layer = getInt("layer", tr, 1)
+		r.AudioExt = normalizeExts(getStringArray("audioext", tr, s.AudioExt))
+		r.TextExt = normalizeExts(getStringArray("textext", tr, s.TextExt))
+		if len(r.AudioExt) == 0 || len(r.TextExt) == 0 {
+			return nil, fmt.Errorf("audioext and textext must not be empty")
+		}
+		r.TextFrom = getTextFrom(tr, s.TextFrom)
 
 		r.File = getString("file", tr, "")
 		r.FileRE = getString("filere", tr, "")
@@ -183,7 +377,10 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 			r.fileRE, err = regexp.Compile(r.FileRE)
 		} else {
 			if r.File == "" {
-				r.File = "*.wav"
+				r.File = "*"
+				if len(r.AudioExt) == 1 {
+					r.File += r.AudioExt[0]
+				}
 			}
 			r.fileRE, err = makeWildcard(r.File)
 		}
@@ -191,6 +388,20 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 			return nil, err
 		}
 
+		r.Project = getString("project", tr, "")
+		r.ProjectRE = getString("projectre", tr, "")
+		if r.Project != "" && r.ProjectRE != "" {
+			return nil, fmt.Errorf("project and projectRE cannot be used at the same time")
+		}
+		if r.ProjectRE != "" {
+			r.projectRE, err = regexp.Compile(r.ProjectRE)
+		} else if r.Project != "" {
+			r.projectRE, err = makeProjectWildcard(r.Project)
+		}
+		if err != nil {
+			return nil, err
+		}
+
 		r.Modifier = getString("modifier", tr, "")
 
 		r.Text = getString("text", tr, "")
@@ -201,7 +412,20 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 			}
 		}
 
+		r.MinDuration = getInt("minduration", tr, 0)
+		r.MaxDuration = getInt("maxduration", tr, 0)
+		if r.MinDuration < 0 || r.MaxDuration < 0 {
+			return nil, fmt.Errorf("minduration and maxduration must not be negative")
+		}
+		if r.MaxDuration > 0 && r.MinDuration > r.MaxDuration {
+			return nil, fmt.Errorf("minduration must be less than or equal to maxduration")
+		}
+		r.SampleRate = getInt("samplerate", tr, 0)
+		r.Channels = getInt("channels", tr, 0)
+		r.Bits = getInt("bits", tr, 0)
+
 		r.UserData = getString("userdata", tr, "")
+		r.Continue = getBool("continue", tr, false)
 
 		r.DeleteText = getBool("deletetext", tr, s.DeleteText)
 		r.ExoFile = getString("exofile", tr, s.ExoFile)
@@ -215,6 +439,51 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 		r.MoveDelay = getFloat64("movedelay", tr, s.MoveDelay)
 		r.LuaFile = getString("luafile", tr, s.LuaFile)
 		r.Padding = getInt("padding", tr, s.Padding)
+		r.CatchUp = getFloat64("catchup", tr, s.CatchUp)
+		r.Freshness = getFloat64("freshness", tr, s.Freshness)
+		r.Delta = getFloat64("delta", tr, s.Delta)
+		if r.Sort, err = getSort(tr, s.Sort); err != nil {
+			return nil, err
+		}
+		r.SortDelay = getFloat64("sortdelay", tr, s.SortDelay)
+		r.SortKey = getString("sortkey", tr, s.SortKey)
+		r.SortFunc = getString("sortfunc", tr, s.SortFunc)
+		switch {
+		case r.Sort == "sortkey" && r.SortKey == "":
+			return nil, fmt.Errorf("sortkey is required for sort = 'sortkey'")
+		case r.Sort == "lua" && r.SortFunc == "":
+			return nil, fmt.Errorf("sortfunc is required for sort = 'lua'")
+		case r.Sort == "lua":
+			if err = compileSortFunc(r.SortFunc); err != nil {
+				return nil, err
+			}
+		}
+		r.AcceptEmptyText = getBool("acceptemptytext", tr, s.AcceptEmptyText)
+		r.BatchMarker = getString("batchmarker", tr, "")
+		if r.BatchMarker != "" {
+			if strings.ContainsAny(r.BatchMarker, `/\`) {
+				return nil, fmt.Errorf("batchmarker must be a filename")
+			}
+			if r.batchMarkerRE, err = makeWildcard(r.BatchMarker); err != nil {
+				return nil, err
+			}
+		}
+		r.BatchProcess = getString("batchprocess", tr, "")
+
+		for _, kv := range [][2]string{
+			{"layer", r.LayerExpr},
+			{"userdata", r.UserData},
+			{"exofile", r.ExoFile},
+			{"luafile", r.LuaFile},
+			{"destdir", r.DestDir},
+			{"sortkey", r.SortKey},
+		} {
+			for _, name := range capNames(kv[1]) {
+				if !r.hasCapture(name) {
+					return nil, fmt.Errorf("capture group %q used in %s is not found in filere or text", name, kv[0])
+				}
+			}
+		}
 
 		s.Rule = append(s.Rule, r)
 	}
@@ -241,6 +510,7 @@ func newSetting(r io.Reader, tempDir string, projectDir string) (*setting, error
 		s.Asas = append(s.Asas, a)
 	}
 
+	s.warnings = analyzeRules(&s)
 	return &s, nil
 }
 
@@ -250,168 +520,474 @@ var (
 	utf16be  = unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
 )
 
-func (ss *setting) Find(path string) (*rule, string, error) {
-	dir := filepath.Dir(path)
-	dirFI, err := getFileInfo(dir)
+// match is the result of setting.Find.
+type match struct {
+	Rule     *rule
+	Text     string
+	TextPath string
+	// Encoding is the encoding used to decode the text. It is the detected one if the rule uses 'auto'.
+	// It is empty if the text is not decoded.
+	Encoding string
+	// SubDir is the relative path from the rule's dir to the directory containing the file.
+	// It is empty unless the rule is recursive.
+	SubDir string
+	// Captures holds the named capture groups of filere and text.
+	Captures map[string]string
+	// Layer is the layer resolved from the rule's layer.
+	Layer int
+}
+
+// expandCaptures replaces %CAP:name% in s with the captured string.
+func (m *match) expandCaptures(s string) string {
+	return m.Rule.dirReplacer.expand(s, func(name string) (string, bool) {
+		if !strings.HasPrefix(name, "CAP:") {
+			return "", false
+		}
+		v, ok := m.Captures[name[4:]]
+		return v, ok
+	})
+}
+
+// expandPathCaptures is the same as expandCaptures but sanitizes the captured string by sanitizePathToken.
+func (m *match) expandPathCaptures(s string) string {
+	return m.Rule.dirReplacer.expand(s, func(name string) (string, bool) {
+		if !strings.HasPrefix(name, "CAP:") {
+			return "", false
+		}
+		v, ok := m.Captures[name[4:]]
+		return sanitizePathToken(v), ok
+	})
+}
+
+// DestDir returns the destination folder for the matched file.
+func (m *match) DestDir(projectPath string, now time.Time) string {
+	return m.Rule.dirReplacer.ReplaceWith(m.Rule.DestDir, fileVars(projectPath, now, m.Rule.index, m.Captures))
+}
+
+// subDir returns the relative path from the rule's dir to dir.
+// Non-recursive rules accept only the rule's dir itself.
+func (r *rule) subDir(dir string) (string, bool, error) {
+	ruleDir := r.ExpandedDir()
+	ruledirFI, err := getFileInfo(ruleDir)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get directory info: %w", err)
+		return "", false, err
+	}
+	d := dir
+	for {
+		fi, err := getFileInfo(d)
+		if err != nil {
+			return "", false, err
+		}
+		if isSameFileInfo(fi, ruledirFI) {
+			if d == dir {
+				return "", true, nil
+			}
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return "", false, err
+			}
+			return rel, true, nil
+		}
+		if !r.Recursive {
+			return "", false, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", false, nil
+		}
+		d = parent
+	}
+}
+
+var encodingNames = map[string]string{
+	"utf8":    "UTF-8",
+	"sjis":    "Shift_JIS",
+	"utf16le": "UTF-16LE",
+	"utf16be": "UTF-16BE",
+}
+
+func decodeText(raw []byte, encoding string) (string, error) {
+	switch encoding {
+	case "utf8":
+		return string(skipUTF8BOM(raw)), nil
+	case "sjis":
+		b, err := shiftjis.NewDecoder().Bytes(raw)
+		return string(b), err
+	case "utf16le":
+		b, err := utf16le.NewDecoder().Bytes(raw)
+		return string(b), err
+	case "utf16be":
+		b, err := utf16be.NewDecoder().Bytes(raw)
+		return string(b), err
 	}
+	return "", fmt.Errorf("unknown encoding: %s", encoding)
+}
+
+// encodingReadable returns the display name of the encoding.
+func encodingReadable(encoding string) string {
+	if encoding == "auto" {
+		return "自動判別"
+	}
+	return encodingNames[encoding]
+}
+
+func encodeText(s string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "utf8":
+		return []byte(s), nil
+	case "sjis":
+		return shiftjis.NewEncoder().Bytes([]byte(s))
+	case "utf16le":
+		return utf16le.NewEncoder().Bytes([]byte(s))
+	case "utf16be":
+		return utf16be.NewEncoder().Bytes([]byte(s))
+	}
+	return nil, fmt.Errorf("unknown encoding: %s", encoding)
+}
+
+// readText reads the text from the sidecar file.
+// *.json is always treated as UTF-8 and its "text" field is used.
+func readText(raw []byte, textPath string, encoding string) (string, error) {
+	if strings.EqualFold(filepath.Ext(textPath), ".json") {
+		var v struct {
+			Text *string `json:"text"`
+		}
+		if err := json.Unmarshal(skipUTF8BOM(raw), &v); err != nil {
+			return "", fmt.Errorf("JSON の解析に失敗しました: %w", err)
+		}
+		if v.Text == nil {
+			return "", fmt.Errorf("JSON に text がありません")
+		}
+		return *v.Text, nil
+	}
+	return decodeText(raw, encoding)
+}
+
+// AcceptsAudio reports whether the rule handles the audio file with the extension.
+func (r *rule) AcceptsAudio(ext string) bool {
+	return containsExt(r.AudioExt, ext)
+}
 
+// textFromFilename returns the text embedded in the filename.
+// It uses the capture group named "text" or the first capture group of filere,
+// or the filename without extension if there is no capture group.
+func (r *rule) textFromFilename(base string) string {
+	if m := r.fileRE.FindStringSubmatch(base); m != nil {
+		if idx := r.fileRE.SubexpIndex("text"); idx > 0 {
+			return m[idx]
+		}
+		if len(m) > 1 {
+			return m[1]
+		}
+	}
+	return changeExt(base, "")
+}
+
+// FindTextFile returns the sidecar text file of the audio file at path.
+// It returns an empty string if there is no such file.
+func (r *rule) FindTextFile(path string) string {
+	return findTextFile(path, r.TextExt)
+}
+
+func findTextFile(path string, exts []string) string {
+	for _, ext := range exts {
+		if p := changeExt(path, ext); exists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// textLoader reads the text for the audio file and caches it for each rule.
+type textLoader struct {
+	path string
+	// raw is used as the content of the text file and the metadata instead of reading files if it is not nil.
+	raw   []byte
+	raws  map[string][]byte
+	texts map[string]string
+	// encodings holds the encoding actually used for each entry of texts.
+	encodings map[string]string
+
+	audio    *audioInfo
+	audioErr error
+}
+
+func newTextLoader(path string, raw []byte) *textLoader {
+	return &textLoader{
+		path:      path,
+		raw:       raw,
+		raws:      map[string][]byte{},
+		texts:     map[string]string{},
+		encodings: map[string]string{},
+	}
+}
+
+// FindTextFile returns the sidecar text file that the rule uses.
+func (tl *textLoader) FindTextFile(r *rule) string {
+	if tl.raw != nil {
+		return changeExt(tl.path, r.TextExt[0])
+	}
+	return r.FindTextFile(tl.path)
+}
+
+// AudioInfo returns the format information of the audio file.
+func (tl *textLoader) AudioInfo() (*audioInfo, error) {
+	if tl.audio == nil && tl.audioErr == nil {
+		tl.audio, tl.audioErr = readAudioInfo(tl.path)
+	}
+	return tl.audio, tl.audioErr
+}
+
+// Load returns the text for the rule and the encoding used to decode it.
+func (tl *textLoader) Load(r *rule, textPath string) (string, string, error) {
+	switch r.TextFrom {
+	case "filename":
+		return r.textFromFilename(filepath.Base(tl.path)), "", nil
+	case "none":
+		return "", "", nil
+	case "metadata":
+		// use a key that never conflicts with file paths.
+		textPath = "\x00metadata"
+	}
+	key := textPath + "\x00" + r.Encoding
+	if t, ok := tl.texts[key]; ok {
+		return t, tl.encodings[key], nil
+	}
+	raw, ok := tl.raws[textPath]
+	if !ok {
+		var err error
+		switch {
+		case tl.raw != nil:
+			raw = tl.raw
+		case r.TextFrom == "metadata":
+			raw, err = readWaveInfoText(tl.path)
+		default:
+			raw, err = os.ReadFile(textPath)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		tl.raws[textPath] = raw
+	}
+	encoding := r.Encoding
+	if r.TextFrom != "metadata" && strings.EqualFold(filepath.Ext(textPath), ".json") {
+		encoding = "utf8"
+	} else if encoding == "auto" {
+		encoding = detectEncoding(raw)
+		if verbose {
+			log.Println(suppress.Renderln("  文字コードを自動判別しました:", encodingNames[encoding]))
+		}
+	}
+	var t string
+	var err error
+	if r.TextFrom == "metadata" {
+		t, err = decodeText(raw, encoding)
+	} else {
+		t, err = readText(raw, textPath, encoding)
+	}
+	if err != nil {
+		return "", encoding, err
+	}
+	tl.texts[key] = t
+	tl.encodings[key] = encoding
+	return t, encoding, nil
+}
+
+// mismatch describes why the rule did not accept the file.
+type mismatch struct {
+	Reason  string
+	Details []string
+}
+
+// evaluate checks whether the rule accepts the audio file of tl.
+// It returns a non-nil *mismatch if the rule does not accept the file.
+// The folder is not checked if ignoreDir is true.
+// projectPath is the project file being edited in AviUtl. It can be empty.
+func (r *rule) evaluate(tl *textLoader, ignoreDir bool, projectPath string) (*match, *mismatch, error) {
+	if mm := r.matchProject(projectPath); mm != nil {
+		return nil, mm, nil
+	}
+	path := tl.path
+	dir := filepath.Dir(path)
+	var subDir string
+	if !ignoreDir {
+		var ok bool
+		var err error
+		subDir, ok, err = r.subDir(dir)
+		if err != nil {
+			return nil, &mismatch{"フォルダーの情報取得に失敗しました", []string{"dir: " + r.ExpandedDir(), "error: " + err.Error()}}, nil
+		}
+		if !ok {
+			return nil, &mismatch{"フォルダーが一致しません", []string{"want: " + r.ExpandedDir(), "got: " + dir}}, nil
+		}
+	}
 	base := filepath.Base(path)
-	textRaw, err := os.ReadFile(path[:len(path)-4] + ".txt")
+	ext := filepath.Ext(path)
+	if !r.AcceptsAudio(ext) {
+		return nil, &mismatch{"音声ファイルの拡張子が対象外です", []string{"ext: " + ext, "audioext: " + strings.Join(r.AudioExt, ", ")}}, nil
+	}
+	fm := r.fileRE.FindStringSubmatch(base)
+	if fm == nil {
+		return nil, &mismatch{"ファイル名がワイルドカードに一致しません", []string{"filename: " + base, "regex: " + r.fileRE.String()}}, nil
+	}
+	captures := map[string]string{}
+	for i, name := range r.fileRE.SubexpNames() {
+		if name != "" {
+			captures[name] = fm[i]
+		}
+	}
+	if r.hasAudioCondition() {
+		ai, err := tl.AudioInfo()
+		if err != nil {
+			return nil, &mismatch{"音声ファイルの情報取得に失敗しました", []string{err.Error()}}, nil
+		}
+		if mm := r.matchAudio(ai); mm != nil {
+			return nil, mm, nil
+		}
+	}
+	var textPath string
+	if r.TextFrom == "file" {
+		if textPath = tl.FindTextFile(r); textPath == "" {
+			return nil, &mismatch{"テキストファイルが見つかりません", []string{"textext: " + strings.Join(r.TextExt, ", ")}}, nil
+		}
+	}
+	if r.textRE != nil {
+		t, _, err := tl.Load(r, textPath)
+		if err != nil {
+			return nil, &mismatch{"テキストの取得に失敗しました", []string{err.Error()}}, nil
+		}
+		tm := r.textRE.FindStringSubmatch(t)
+		if tm == nil {
+			return nil, &mismatch{"テキスト内容が正規表現にマッチしませんでした", []string{"text: " + t, "regex: " + r.textRE.String()}}, nil
+		}
+		for i, name := range r.textRE.SubexpNames() {
+			if name != "" {
+				captures[name] = tm[i]
+			}
+		}
+	}
+	t, encoding, err := tl.Load(r, textPath)
 	if err != nil {
-		return nil, "", err
+		name := encodingNames[encoding]
+		if name == "" {
+			name = encodingReadable(r.Encoding)
+		}
+		if r.TextFrom == "metadata" {
+			return nil, nil, fmt.Errorf("cannot read text from metadata as %s: %w", name, err)
+		}
+		return nil, nil, fmt.Errorf("cannot read text from %s as %s: %w", filepath.Base(textPath), name, err)
+	}
+	m := &match{Rule: r, Text: t, TextPath: textPath, Encoding: encoding, SubDir: subDir, Captures: captures, Layer: r.Layer}
+	if r.LayerExpr != "" {
+		v := m.expandCaptures(r.LayerExpr)
+		if m.Layer, err = strconv.Atoi(v); err != nil {
+			return nil, &mismatch{"レイヤー番号を決定できません", []string{"layer: " + r.LayerExpr, "got: " + v}}, nil
+		}
+	}
+	return m, nil, nil
+}
+
+// matchProject returns a non-nil *mismatch if the project does not satisfy the condition of the rule.
+func (r *rule) matchProject(projectPath string) *mismatch {
+	if r.projectRE == nil {
+		return nil
+	}
+	cond := "project: " + r.Project
+	if r.ProjectRE != "" {
+		cond = "projectre: " + r.ProjectRE
+	}
+	if projectPath == "" {
+		return &mismatch{"AviUtl で編集中のプロジェクトファイルが見つかりません", []string{cond}}
+	}
+	target := projectPath
+	if r.ProjectRE == "" && !strings.ContainsAny(r.Project, `/\`) {
+		target = filepath.Base(projectPath)
+	}
+	if !r.projectRE.MatchString(target) {
+		return &mismatch{"プロジェクトファイルが一致しません", []string{cond, "got: " + projectPath}}
 	}
-	var u8, sjis, u16le, u16be *string
+	return nil
+}
+
+// hasAudioCondition reports whether the rule has any condition on the audio format.
+func (r *rule) hasAudioCondition() bool {
+	return r.MinDuration > 0 || r.MaxDuration > 0 || r.SampleRate > 0 || r.Channels > 0 || r.Bits > 0
+}
+
+// matchAudio returns a non-nil *mismatch if the audio does not satisfy the conditions of the rule.
+func (r *rule) matchAudio(ai *audioInfo) *mismatch {
+	duration := int(ai.Samples * 1000 / int64(ai.SampleRate))
+	if r.MinDuration > 0 && duration < r.MinDuration {
+		return &mismatch{"音声ファイルが minduration より短いです", []string{fmt.Sprintf("duration: %dms", duration), fmt.Sprintf("minduration: %dms", r.MinDuration)}}
+	}
+	if r.MaxDuration > 0 && duration > r.MaxDuration {
+		return &mismatch{"音声ファイルが maxduration より長いです", []string{fmt.Sprintf("duration: %dms", duration), fmt.Sprintf("maxduration: %dms", r.MaxDuration)}}
+	}
+	if r.SampleRate > 0 && ai.SampleRate != r.SampleRate {
+		return &mismatch{"サンプリングレートが一致しません", []string{fmt.Sprintf("want: %dHz", r.SampleRate), fmt.Sprintf("got: %dHz", ai.SampleRate)}}
+	}
+	if r.Channels > 0 && ai.Channels != r.Channels {
+		return &mismatch{"チャンネル数が一致しません", []string{fmt.Sprintf("want: %d", r.Channels), fmt.Sprintf("got: %d", ai.Channels)}}
+	}
+	if r.Bits > 0 && ai.Bits != r.Bits {
+		return &mismatch{"量子化ビット数が一致しません", []string{fmt.Sprintf("want: %d", r.Bits), fmt.Sprintf("got: %d", ai.Bits)}}
+	}
+	return nil
+}
 
+// FileSortKey returns the sortkey of the first rule that accepts the file at path.
+// It returns an empty string if no rule accepts the file.
+func (ss *setting) FileSortKey(path string) string {
+	m, err := ss.Find(path)
+	if err != nil || m == nil {
+		return ""
+	}
+	return m.expandCaptures(m.Rule.SortKey)
+}
+
+// Find returns the first rule that accepts the file at path.
+func (ss *setting) Find(path string) (*match, error) {
+	ms, err := ss.FindAll(path)
+	if err != nil || len(ms) == 0 {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// FindAll returns the rules that accept the file at path.
+// The search continues after a matched rule only if the rule has continue = true.
+func (ss *setting) FindAll(path string) ([]*match, error) {
+	if _, err := getFileInfo(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to get directory info: %w", err)
+	}
+	tl := newTextLoader(path, nil)
+	var r []*match
 	for i := range ss.Rule {
 		if verbose {
 			log.Println(suppress.Renderln(i, "番目のルールを検証中..."))
 		}
-		r := &ss.Rule[i]
-		ruleDir := r.ExpandedDir()
-		ruledirFI, err := getFileInfo(ruleDir)
+		m, mm, err := ss.Rule[i].evaluate(tl, false, ss.projectPath)
 		if err != nil {
-			if verbose {
-				log.Println(suppress.Renderln("  フォルダーの情報取得に失敗しました"))
-				log.Println(suppress.Renderln("    dir:", ruleDir))
-				log.Println(suppress.Renderln("    error:", err))
-			}
-			continue
-		}
-		if !isSameFileInfo(dirFI, ruledirFI) {
-			if verbose {
-				log.Println(suppress.Renderln("  フォルダーが一致しません"))
-				log.Println(suppress.Renderln("    want:", r.ExpandedDir()))
-				log.Println(suppress.Renderln("    got:", dir))
-			}
-			continue
+			return nil, err
 		}
-		if !r.fileRE.MatchString(base) {
+		if mm != nil {
 			if verbose {
-				log.Println(suppress.Renderln("  ファイル名がワイルドカードに一致しません"))
-				log.Println(suppress.Renderln("    filename:", base))
-				log.Println(suppress.Renderln("    regex:", r.fileRE))
-			}
-			continue
-		}
-		if r.textRE != nil {
-			switch r.Encoding {
-			case "utf8":
-				if u8 == nil {
-					t := string(skipUTF8BOM(textRaw))
-					u8 = &t
-				}
-				if !r.textRE.MatchString(*u8) {
-					if verbose {
-						log.Println(suppress.Renderln("    テキスト内容が正規表現にマッチしませんでした"))
-					}
-					continue
-				}
-			case "sjis":
-				if sjis == nil {
-					b, err := shiftjis.NewDecoder().Bytes(textRaw)
-					if err != nil {
-						if verbose {
-							log.Println(suppress.Renderln("    Shift_JIS から UTF-8 への文字コード変換に失敗しました"))
-							log.Println(suppress.Renderln("      ", err))
-						}
-						continue
-					}
-					t := string(b)
-					sjis = &t
-				}
-				if !r.textRE.MatchString(*sjis) {
-					if verbose {
-						log.Println(suppress.Renderln("    テキスト内容が正規表現にマッチしませんでした"))
-					}
-					continue
-				}
-			case "utf16le":
-				if u16le == nil {
-					b, err := utf16le.NewDecoder().Bytes(textRaw)
-					if err != nil {
-						if verbose {
-							log.Println(suppress.Renderln("    UTF-16LE から UTF-8 への文字コード変換に失敗しました"))
-							log.Println(suppress.Renderln("      ", err))
-						}
-						continue
-					}
-					t := string(b)
-					u16le = &t
-				}
-				if !r.textRE.MatchString(*u16le) {
-					if verbose {
-						log.Println(suppress.Renderln("    テキスト内容が正規表現にマッチしませんでした"))
-					}
-					continue
-				}
-			case "utf16be":
-				if u16be == nil {
-					b, err := utf16be.NewDecoder().Bytes(textRaw)
-					if err != nil {
-						if verbose {
-							log.Println(suppress.Renderln("    UTF-16BE から UTF-8 への文字コード変換に失敗しました"))
-							log.Println(suppress.Renderln("      ", err))
-						}
-						continue
-					}
-					t := string(b)
-					u16be = &t
-				}
-				if !r.textRE.MatchString(*u16be) {
-					if verbose {
-						log.Println(suppress.Renderln("    テキスト内容が正規表現にマッチしませんでした"))
-					}
-					continue
+				log.Println(suppress.Renderln("  " + mm.Reason))
+				for _, d := range mm.Details {
+					log.Println(suppress.Renderln("    " + d))
 				}
 			}
+			continue
 		}
 		if verbose {
 			log.Println(suppress.Renderln("  このルールに適合しました"))
 		}
-		switch r.Encoding {
-		case "utf8":
-			if u8 == nil {
-				t := string(skipUTF8BOM(textRaw))
-				u8 = &t
-			}
-			return r, *u8, nil
-		case "sjis":
-			if sjis == nil {
-				b, err := shiftjis.NewDecoder().Bytes(textRaw)
-				if err != nil {
-					return nil, "", fmt.Errorf("cannot convert encoding to shift_jis: %w", err)
-				}
-				t := string(b)
-				sjis = &t
-			}
-			return r, *sjis, nil
-		case "utf16le":
-			if u16le == nil {
-				b, err := utf16le.NewDecoder().Bytes(textRaw)
-				if err != nil {
-					return nil, "", fmt.Errorf("cannot convert encoding to utf-16le: %w", err)
-				}
-				t := string(b)
-				u16le = &t
-			}
-			return r, *u16le, nil
-		case "utf16be":
-			if u16be == nil {
-				b, err := utf16be.NewDecoder().Bytes(textRaw)
-				if err != nil {
-					return nil, "", fmt.Errorf("cannot convert encoding to utf-16be: %w", err)
-				}
-				t := string(b)
-				u16be = &t
-			}
-			return r, *u16be, nil
-		default:
-			panic("unexcepted encoding value: " + r.Encoding)
+		r = append(r, m)
+		if !m.Rule.Continue {
+			break
 		}
 	}
-	return nil, "", nil
+	return r, nil
 }
 
 func (ss *setting) Dirs() []string {
@@ -429,6 +1005,207 @@ func (ss *setting) Dirs() []string {
 	return r
 }
 
+// dirOptions is the options applied to the files in a folder.
+type dirOptions struct {
+	Freshness       float64
+	Delta           float64
+	Sort            string
+	SortDelay       float64
+	SortFunc        string
+	AcceptEmptyText bool
+	BatchMarker     string
+	BatchProcess    string
+}
+
+// DirOptions returns the options for the files in dir.
+// If several rules watch the folder, the loosest values are used so that no rule misses its files:
+// the largest freshness and delta (0 means no limit), the largest sortdelay and acceptemptytext if any rule accepts.
+// sort and sortfunc are taken from the first rule, and batchmarker and batchprocess from the first rule that has them.
+func (ss *setting) DirOptions(dir string) dirOptions {
+	var o dirOptions
+	found := false
+	loosest := func(a, b float64) float64 {
+		if a <= 0 || b <= 0 {
+			return 0
+		}
+		return math.Max(a, b)
+	}
+	for i := range ss.Rule {
+		r := &ss.Rule[i]
+		if !r.containsDir(dir, false) {
+			continue
+		}
+		if o.BatchMarker == "" {
+			o.BatchMarker = r.BatchMarker
+		}
+		if o.BatchProcess == "" {
+			o.BatchProcess = r.BatchProcess
+		}
+		if !found {
+			o.Freshness, o.Delta, o.Sort, o.SortFunc, o.SortDelay, o.AcceptEmptyText = r.Freshness, r.Delta, r.Sort, r.SortFunc, r.SortDelay, r.AcceptEmptyText
+			found = true
+			continue
+		}
+		o.Freshness = loosest(o.Freshness, r.Freshness)
+		o.Delta = loosest(o.Delta, r.Delta)
+		o.SortDelay = math.Max(o.SortDelay, r.SortDelay)
+		o.AcceptEmptyText = o.AcceptEmptyText || r.AcceptEmptyText
+	}
+	if !found {
+		return dirOptions{Freshness: ss.Freshness, Delta: ss.Delta, Sort: ss.Sort, SortFunc: ss.SortFunc, SortDelay: ss.SortDelay, AcceptEmptyText: ss.AcceptEmptyText}
+	}
+	return o
+}
+
+type catchUpTarget struct {
+	Age       float64
+	Recursive bool
+}
+
+// CatchUpDirs returns the directories to be scanned on start.
+func (ss *setting) CatchUpDirs() map[string]catchUpTarget {
+	dirs := map[string]catchUpTarget{}
+	for i := range ss.Rule {
+		r := &ss.Rule[i]
+		if r.CatchUp <= 0 || !r.ExistsDir() {
+			continue
+		}
+		dir := r.ExpandedDir()
+		t := dirs[dir]
+		if r.CatchUp > t.Age {
+			t.Age = r.CatchUp
+		}
+		t.Recursive = t.Recursive || r.Recursive
+		dirs[dir] = t
+	}
+	return dirs
+}
+
+func appendUniqueExts(dst []string, exts []string) []string {
+	for _, ext := range exts {
+		found := false
+		for _, e := range dst {
+			if e == ext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, ext)
+		}
+	}
+	return dst
+}
+
+// AudioExts returns the audio extensions used by any rule.
+func (ss *setting) AudioExts() []string {
+	if len(ss.Rule) == 0 {
+		return ss.AudioExt
+	}
+	var r []string
+	for i := range ss.Rule {
+		r = appendUniqueExts(r, ss.Rule[i].AudioExt)
+	}
+	return r
+}
+
+// TextExts returns the text sidecar extensions used by any rule.
+func (ss *setting) TextExts() []string {
+	if len(ss.Rule) == 0 {
+		return ss.TextExt
+	}
+	var r []string
+	for i := range ss.Rule {
+		r = appendUniqueExts(r, ss.Rule[i].TextExt)
+	}
+	return r
+}
+
+// IsAudioFile reports whether path has one of the audio extensions.
+func (ss *setting) IsAudioFile(path string) bool {
+	return containsExt(ss.AudioExts(), filepath.Ext(path))
+}
+
+// IsTextFile reports whether path has one of the text sidecar extensions.
+func (ss *setting) IsTextFile(path string) bool {
+	return containsExt(ss.TextExts(), filepath.Ext(path))
+}
+
+// AcceptsLoneAudio reports whether any rule watching the folder of path can handle the audio file without a text file.
+func (ss *setting) AcceptsLoneAudio(path string) bool {
+	dir, ext := filepath.Dir(path), filepath.Ext(path)
+	for i := range ss.Rule {
+		r := &ss.Rule[i]
+		if r.TextFrom != "file" && r.AcceptsAudio(ext) && r.containsDir(dir, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindTextFile returns the sidecar text file of the audio file at path using any of the text extensions.
+func (ss *setting) FindTextFile(path string) string {
+	return findTextFile(path, ss.TextExts())
+}
+
+// FindAudioFiles returns the existing audio files that have the same name as path.
+func (ss *setting) FindAudioFiles(path string) []string {
+	var r []string
+	for _, ext := range ss.AudioExts() {
+		if p := changeExt(path, ext); exists(p) {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+func containsExt(exts []string, ext string) bool {
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// PollDirs returns the directories that should be watched by polling.
+// The value reports whether subdirectories are also watched.
+func (ss *setting) PollDirs() map[string]bool {
+	dirs := map[string]bool{}
+	for i := range ss.Rule {
+		if ss.Rule[i].WatchMode == "poll" && ss.Rule[i].ExistsDir() {
+			dirs[ss.Rule[i].ExpandedDir()] = false
+		}
+	}
+	for i := range ss.Rule {
+		dir := ss.Rule[i].ExpandedDir()
+		if _, ok := dirs[dir]; ok && ss.Rule[i].Recursive {
+			dirs[dir] = true
+		}
+	}
+	return dirs
+}
+
+// RecursiveDirs returns the directories that should be watched including subdirectories by fsnotify.
+func (ss *setting) RecursiveDirs() []string {
+	pollDirs := ss.PollDirs()
+	dirs := map[string]struct{}{}
+	for i := range ss.Rule {
+		if ss.Rule[i].Recursive && ss.Rule[i].ExistsDir() {
+			dir := ss.Rule[i].ExpandedDir()
+			if _, ok := pollDirs[dir]; !ok {
+				dirs[dir] = struct{}{}
+			}
+		}
+	}
+	r := make([]string, 0, len(dirs))
+	for k := range dirs {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
 func loadTOML(r io.Reader) (*toml.Tree, error) {
 	rr := bufio.NewReader(r)
 	b, err := rr.Peek(3)
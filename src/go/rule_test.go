@@ -0,0 +1,655 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func writeTestWave(t *testing.T, path string, samples int) {
+	t.Helper()
+	const rate, ch, bits = 48000, 1, 16
+	dataSize := samples * ch * bits / 8
+	b := make([]byte, 44+dataSize)
+	copy(b[0:], "RIFF")
+	binary.LittleEndian.PutUint32(b[4:], uint32(36+dataSize))
+	copy(b[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(b[16:], 16)
+	binary.LittleEndian.PutUint16(b[20:], 1)
+	binary.LittleEndian.PutUint16(b[22:], ch)
+	binary.LittleEndian.PutUint32(b[24:], rate)
+	binary.LittleEndian.PutUint32(b[28:], rate*ch*bits/8)
+	binary.LittleEndian.PutUint16(b[32:], ch*bits/8)
+	binary.LittleEndian.PutUint16(b[34:], bits)
+	copy(b[36:], "data")
+	binary.LittleEndian.PutUint32(b[40:], uint32(dataSize))
+	if err := os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestWaveInfo writes the wave file that has LIST/INFO chunk.
+func writeTestWaveInfo(t *testing.T, path string, samples int, info map[string]string) {
+	t.Helper()
+	writeTestWave(t, path, samples)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []byte("INFO")
+	for id, v := range info {
+		v += "\x00"
+		sz := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sz, uint32(len(v)))
+		list = append(append(append(list, id...), sz...), v...)
+		if len(v)&1 == 1 {
+			list = append(list, 0)
+		}
+	}
+	sz := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sz, uint32(len(list)))
+	b = append(append(append(b, "LIST"...), sz...), list...)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	if err = os.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestText(t *testing.T, path string, text string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(text), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMakeWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"*.wav", "hello.wav", true},
+		{"*.wav", "hello.txt", false},
+		{"*.wav", `dir\hello.wav`, false},
+		{"ボイロ2_*.wav", "ボイロ2_20201231235959.wav", true},
+		{"ボイロ2_*.wav", "きりたん_20201231235959.wav", false},
+		{"a?c.wav", "abc.wav", true},
+		{"a?c.wav", "abbc.wav", false},
+		{"a.c", "abc", false},
+		{"(1)*.wav", "(1)x.wav", true},
+	}
+	for idx, data := range tests {
+		re, err := makeWildcard(data.pattern)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if got := re.MatchString(data.s); got != data.match {
+			t.Errorf("No.%d: %q with %q: want %v got %v", idx, data.pattern, data.s, data.match, got)
+		}
+	}
+}
+
+func TestNewSetting(t *testing.T) {
+	s, err := newSetting(strings.NewReader(`
+delta = 3.5
+filemove = 'copy'
+
+[[rule]]
+file = '*_a_*.wav'
+layer = 3
+
+[[rule]]
+filere = '^b_.*\.wav$'
+filemove = 'move'
+`), "tmp", "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Delta != 3.5 {
+		t.Errorf("Delta: want %v got %v", 3.5, s.Delta)
+	}
+	if s.Sort != "moddate" {
+		t.Errorf("Sort: want %v got %v", "moddate", s.Sort)
+	}
+	if len(s.Rule) != 2 {
+		t.Fatalf("len(Rule): want %v got %v", 2, len(s.Rule))
+	}
+	if s.Rule[0].Layer != 3 || s.Rule[0].FileMove != "copy" || s.Rule[0].ExpandedDir() != "tmp" {
+		t.Errorf("Rule[0]: unexpected value %+v", s.Rule[0])
+	}
+	if s.Rule[1].Layer != 1 || s.Rule[1].FileMove != "move" || s.Rule[1].ExpandedDestDir() != "proj" {
+		t.Errorf("Rule[1]: unexpected value %+v", s.Rule[1])
+	}
+
+	if _, err = newSetting(strings.NewReader(`
+[[rule]]
+file = '*.wav'
+filere = '.*'
+`), "tmp", ""); err == nil {
+		t.Errorf("file and filere should not be used at the same time")
+	}
+
+	for i, test := range []struct {
+		Setting string
+		Err     string
+	}{
+		{"sort = 'unknown'", "unknown sort: unknown"},
+		{"[[rule]]\nsort = 'sortkey'", "sortkey is required for sort = 'sortkey'"},
+		{"[[rule]]\nsort = 'lua'", "sortfunc is required for sort = 'lua'"},
+		{"[[rule]]\nsort = 'lua'\nsortfunc = 'return a.path <'", "sortfunc:"},
+		{"[[rule]]\nsort = 'sortkey'\nsortkey = '%CAP:index%'", `capture group "index" used in sortkey is not found in filere or text`},
+	} {
+		_, err := newSetting(strings.NewReader(test.Setting), "tmp", "")
+		if err == nil || !strings.HasPrefix(err.Error(), test.Err) {
+			t.Errorf("No.%d: want %q got %v", i, test.Err, err)
+		}
+	}
+}
+
+func TestDirOptions(t *testing.T) {
+	dir := t.TempDir()
+	cevio := filepath.Join(dir, "cevio")
+	voiceroid := filepath.Join(dir, "voiceroid")
+	s, err := newSetting(strings.NewReader(`
+freshness = 5.0
+delta = 15.0
+sortdelay = 0.1
+
+[[rule]]
+dir = '`+cevio+`'
+sort = 'name'
+sortdelay = 2.0
+freshness = 0.0
+
+[[rule]]
+dir = '`+cevio+`'
+delta = 30.0
+acceptemptytext = true
+batchmarker = '*.done'
+
+[[rule]]
+dir = '`+voiceroid+`'
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir  string
+		want dirOptions
+	}{
+		{cevio, dirOptions{Freshness: 0, Delta: 30, Sort: "name", SortDelay: 2, AcceptEmptyText: true, BatchMarker: "*.done"}},
+		{voiceroid, dirOptions{Freshness: 5, Delta: 15, Sort: "moddate", SortDelay: 0.1}},
+		{dir, dirOptions{Freshness: 5, Delta: 15, Sort: "moddate", SortDelay: 0.1}},
+	}
+	for idx, data := range tests {
+		if got := s.DirOptions(data.dir); got != data.want {
+			t.Errorf("No.%d: want %+v got %+v", idx, data.want, got)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+file = 'voice_*.wav'
+encoding = 'utf8'
+text = '^きりたん＞'
+layer = 1
+
+[[rule]]
+file = 'voice_*.wav'
+encoding = 'sjis'
+layer = 2
+
+[[rule]]
+file = 'never_*.wav'
+layer = 3
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sjis, err := japanese.ShiftJIS.NewEncoder().String("こんにちは")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		text  string
+		layer int
+		want  string
+	}{
+		{"voice_1", "\xef\xbb\xbfきりたん＞こんにちは", 1, "きりたん＞こんにちは"},
+		{"voice_2", sjis, 2, "こんにちは"},
+		{"other", "こんにちは", 0, ""},
+	}
+	for idx, data := range tests {
+		wavPath := filepath.Join(dir, data.name+".wav")
+		writeTestWave(t, wavPath, 100)
+		writeTestText(t, filepath.Join(dir, data.name+".txt"), data.text)
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if data.layer == 0 {
+			if m != nil {
+				t.Errorf("No.%d: want no rule got layer %d", idx, m.Rule.Layer)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		if m.Rule.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, m.Rule.Layer)
+		}
+		if m.Text != data.want {
+			t.Errorf("No.%d: text: want %q got %q", idx, data.want, m.Text)
+		}
+	}
+}
+
+func TestFindRecursive(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+file = 'flat_*.wav'
+encoding = 'utf8'
+layer = 1
+
+[[rule]]
+encoding = 'utf8'
+recursive = true
+layer = 2
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		subDir string
+		name   string
+		layer  int
+	}{
+		{"", "flat_1", 1},
+		{"", "voice_1", 2},
+		{filepath.Join("2024-01", "きりたん"), "flat_2", 2},
+		{"2024-02", "voice_2", 2},
+	}
+	for idx, data := range tests {
+		d := filepath.Join(dir, data.subDir)
+		if err = os.MkdirAll(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+		wavPath := filepath.Join(d, data.name+".wav")
+		writeTestWave(t, wavPath, 100)
+		writeTestText(t, changeExt(wavPath, ".txt"), data.name)
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		if m.Rule.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, m.Rule.Layer)
+		}
+		if m.SubDir != data.subDir {
+			t.Errorf("No.%d: subdir: want %q got %q", idx, data.subDir, m.SubDir)
+		}
+	}
+}
+
+func TestFindExtensions(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+audioext = ['.ogg', '.wav']
+textext = ['.json', '.txt']
+
+[[rule]]
+encoding = 'utf8'
+layer = 1
+
+[[rule]]
+encoding = 'utf8'
+audioext = 'FLAC'
+textext = 'lab'
+layer = 2
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Rule[0].File != "*" || s.Rule[1].File != "*.flac" {
+		t.Errorf("unexpected default file pattern %q / %q", s.Rule[0].File, s.Rule[1].File)
+	}
+	tests := []struct {
+		audio string
+		texts map[string]string
+		layer int
+		want  string
+	}{
+		{"a.ogg", map[string]string{".json": `{"text":"ジェイソン"}`, ".txt": "テキスト"}, 1, "ジェイソン"},
+		{"b.wav", map[string]string{".txt": "テキスト"}, 1, "テキスト"},
+		{"c.flac", map[string]string{".lab": "ラベル"}, 2, "ラベル"},
+		{"d.flac", map[string]string{".txt": "テキスト"}, 0, ""},
+		{"e.mp3", map[string]string{".txt": "テキスト"}, 0, ""},
+	}
+	for idx, data := range tests {
+		audioPath := filepath.Join(dir, data.audio)
+		writeTestWave(t, audioPath, 100)
+		for ext, text := range data.texts {
+			writeTestText(t, changeExt(audioPath, ext), text)
+		}
+		if got, want := s.IsAudioFile(audioPath), filepath.Ext(audioPath) != ".mp3"; got != want {
+			t.Errorf("No.%d: IsAudioFile: want %v got %v", idx, want, got)
+		}
+		m, err := s.Find(audioPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if data.layer == 0 {
+			if m != nil {
+				t.Errorf("No.%d: want no rule got layer %d", idx, m.Rule.Layer)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		if m.Rule.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, m.Rule.Layer)
+		}
+		if m.Text != data.want {
+			t.Errorf("No.%d: text: want %q got %q", idx, data.want, m.Text)
+		}
+	}
+}
+
+func TestFindTextFrom(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+filere = '^se_(?P<num>\d+)_(?P<text>.+)\.wav$'
+textfrom = 'filename'
+layer = 1
+
+[[rule]]
+filere = '^group_(.+)\.wav$'
+textfrom = 'filename'
+layer = 2
+
+[[rule]]
+file = 'narr_*.wav'
+encoding = 'utf8'
+textfrom = 'metadata'
+layer = 3
+
+[[rule]]
+file = 'bgm_*.wav'
+textfrom = 'none'
+layer = 4
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.AcceptsLoneAudio(filepath.Join(dir, "a.wav")) || s.AcceptsLoneAudio(filepath.Join(dir, "a.ogg")) {
+		t.Errorf("unexpected AcceptsLoneAudio result")
+	}
+	textDir := filepath.Join(dir, "text")
+	if err = os.Mkdir(textDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := newSetting(strings.NewReader(`
+[[rule]]
+dir = '`+textDir+`'
+layer = 1
+
+[[rule]]
+textfrom = 'filename'
+layer = 2
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the audio-only rule watches the other folder.
+	if s2.AcceptsLoneAudio(filepath.Join(textDir, "a.wav")) || !s2.AcceptsLoneAudio(filepath.Join(dir, "a.wav")) {
+		t.Errorf("AcceptsLoneAudio should check the folder of the rules")
+	}
+	tests := []struct {
+		name  string
+		info  map[string]string
+		layer int
+		want  string
+	}{
+		{"se_01_拍手", nil, 1, "拍手"},
+		{"group_ドア", nil, 2, "ドア"},
+		{"narr_1", map[string]string{"ICMT": "コメント", "INAM": "タイトル"}, 3, "タイトル"},
+		{"narr_2", map[string]string{"ICMT": "コメント"}, 3, "コメント"},
+		{"bgm_1", nil, 4, ""},
+		{"other", nil, 0, ""},
+	}
+	for idx, data := range tests {
+		wavPath := filepath.Join(dir, data.name+".wav")
+		writeTestWaveInfo(t, wavPath, 100, data.info)
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if data.layer == 0 {
+			if m != nil {
+				t.Errorf("No.%d: want no rule got layer %d", idx, m.Rule.Layer)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		if m.Rule.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, m.Rule.Layer)
+		}
+		if m.Text != data.want {
+			t.Errorf("No.%d: text: want %q got %q", idx, data.want, m.Text)
+		}
+		if m.TextPath != "" {
+			t.Errorf("No.%d: want no text file got %q", idx, m.TextPath)
+		}
+	}
+
+	writeTestWave(t, filepath.Join(dir, "narr_3.wav"), 100)
+	if _, err = s.Find(filepath.Join(dir, "narr_3.wav")); err == nil {
+		t.Errorf("want error for wave file without LIST/INFO")
+	}
+}
+
+func TestFindAudioCondition(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+textfrom = 'none'
+
+[[rule]]
+maxduration = 500
+layer = 1
+
+[[rule]]
+minduration = 1000
+maxduration = 2000
+layer = 2
+
+[[rule]]
+samplerate = 44100
+layer = 3
+
+[[rule]]
+bits = 24
+layer = 4
+
+[[rule]]
+samplerate = 48000
+channels = 1
+bits = 16
+layer = 5
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		samples int
+		layer   int
+	}{
+		{4800, 1},
+		{24000, 1},
+		{24048, 5},
+		{48000, 2},
+		{96000, 2},
+		{144000, 5},
+	}
+	for idx, data := range tests {
+		wavPath := filepath.Join(dir, fmt.Sprintf("%d.wav", idx))
+		writeTestWave(t, wavPath, data.samples)
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		if m.Rule.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, m.Rule.Layer)
+		}
+	}
+
+	if _, err = newSetting(strings.NewReader("[[rule]]\nminduration = 2000\nmaxduration = 1000"), dir, ""); err == nil {
+		t.Errorf("want error for minduration > maxduration")
+	}
+}
+
+func TestFindCaptures(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+filere = '^(?P<layer>\d+)_(?P<char>[^_]+)_.+\.wav$'
+encoding = 'utf8'
+text = '^(?P<mood>[^＞]+)＞'
+layer = '%CAP:layer%'
+userdata = '%CAP:char%/%CAP:mood%'
+exofile = '%BASEDIR%\%CAP:char%.exo'
+luafile = '%CAP:unknown%.lua'
+
+[[rule]]
+encoding = 'utf8'
+layer = 9
+`), dir, "")
+	if err == nil || err.Error() != `capture group "unknown" used in luafile is not found in filere or text` {
+		t.Fatalf("want capture group error got %v", err)
+	}
+	s, err = newSetting(strings.NewReader(`
+[[rule]]
+filere = '^(?P<layer>\w+)_(?P<char>[^_]+)_.+\.wav$'
+encoding = 'utf8'
+text = '^(?P<mood>[^＞]+)＞'
+layer = '%CAP:layer%'
+userdata = '%CAP:char%/%CAP:mood%'
+exofile = '%BASEDIR%\%CAP:char%.exo'
+
+[[rule]]
+encoding = 'utf8'
+layer = 9
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		text     string
+		layer    int
+		userdata string
+		exofile  string
+	}{
+		{"3_きりたん_01", "笑顔＞こんにちは", 3, "きりたん/笑顔", `%BASEDIR%\きりたん.exo`},
+		{"12_ずんだもん_01", "怒り＞なのだ", 12, "ずんだもん/怒り", `%BASEDIR%\ずんだもん.exo`},
+		{"5_..きりたん.._01", "笑顔＞こんにちは", 5, "..きりたん../笑顔", `%BASEDIR%\きりたん.exo`},
+		{"x_ずんだもん_01", "怒り＞なのだ", 9, "", "template.exo"},
+		{"4_ずんだもん_01", "なのだ", 9, "", "template.exo"},
+	}
+	for idx, data := range tests {
+		wavPath := filepath.Join(dir, data.name+".wav")
+		writeTestWave(t, wavPath, 100)
+		writeTestText(t, changeExt(wavPath, ".txt"), data.text)
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if m == nil {
+			t.Errorf("No.%d: want layer %d got no rule", idx, data.layer)
+			continue
+		}
+		mv := newModifierVars(m, wavPath)
+		if mv.Layer != data.layer {
+			t.Errorf("No.%d: layer: want %d got %d", idx, data.layer, mv.Layer)
+		}
+		if mv.UserData.String() != data.userdata || mv.ExoFile.String() != data.exofile {
+			t.Errorf("No.%d: want %q %q got %q %q", idx, data.userdata, data.exofile, mv.UserData, mv.ExoFile)
+		}
+	}
+}
+
+func TestFindProject(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+project = 'ゆっくり*.aup'
+encoding = 'utf8'
+layer = 1
+
+[[rule]]
+projectre = '[/\\]ボイロ[/\\]'
+encoding = 'utf8'
+layer = 2
+
+[[rule]]
+encoding = 'utf8'
+layer = 3
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wavPath := filepath.Join(dir, "a.wav")
+	writeTestWave(t, wavPath, 100)
+	writeTestText(t, changeExt(wavPath, ".txt"), "こんにちは")
+	tests := []struct {
+		projectPath string
+		layer       int
+	}{
+		{filepath.Join(dir, "ゆっくり解説01.AUP"), 1},
+		{filepath.Join(dir, "ボイロ", "01.aup"), 2},
+		{filepath.Join(dir, "ゆっくり", "01.aup"), 3},
+		{"", 3},
+	}
+	for idx, data := range tests {
+		s.projectPath = data.projectPath
+		m, err := s.Find(wavPath)
+		if err != nil {
+			t.Errorf("No.%d: failed: %v", idx, err)
+			continue
+		}
+		if m == nil || m.Layer != data.layer {
+			t.Errorf("No.%d: want layer %d got %v", idx, data.layer, m)
+		}
+	}
+}
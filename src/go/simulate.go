@@ -0,0 +1,343 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// simulateInput is the file to be evaluated by simulate.
+type simulateInput struct {
+	// Path is the path of the audio file. It can be a filename without folder.
+	Path string
+	// Text is used as the content of the text file if it is not nil.
+	Text *string
+	// Encoding is the encoding of Text.
+	Encoding string
+	// ProjectPath is the path of the project file used for %PROJECTNAME% and project conditions.
+	ProjectPath string
+}
+
+// IgnoreDir reports whether the folder of rules cannot be checked.
+func (in *simulateInput) IgnoreDir() bool {
+	return filepath.Base(in.Path) == in.Path
+}
+
+// ruleSimulation is the result of evaluating a rule.
+type ruleSimulation struct {
+	Rule     *rule
+	Match    *match
+	Mismatch *mismatch
+	// Vars holds the values after the modifier script is executed.
+	Vars *modifierVars
+	Err  error
+}
+
+// simulate evaluates all rules against the file without touching any files.
+func simulate(ss *setting, in simulateInput) ([]ruleSimulation, error) {
+	var raw []byte
+	if in.Text != nil {
+		var err error
+		if raw, err = encodeText(*in.Text, in.Encoding); err != nil {
+			return nil, err
+		}
+	}
+	tl := newTextLoader(in.Path, raw)
+	r := make([]ruleSimulation, 0, len(ss.Rule))
+	for i := range ss.Rule {
+		rs := ruleSimulation{Rule: &ss.Rule[i]}
+		rs.Match, rs.Mismatch, rs.Err = rs.Rule.evaluate(tl, in.IgnoreDir(), in.ProjectPath)
+		if rs.Match != nil {
+			rs.Vars = newModifierVars(rs.Match, in.Path)
+			if rs.Rule.Modifier != "" {
+				rs.Err = runModifier(rs.Match, in.Path, rs.Vars, nil)
+			}
+		}
+		r = append(r, rs)
+	}
+	return r, nil
+}
+
+// firstMatch returns the simulation of the rule that is actually used.
+func firstMatch(sims []ruleSimulation) *ruleSimulation {
+	if used := usedMatches(sims); len(used) > 0 {
+		return used[0]
+	}
+	return nil
+}
+
+// usedMatches returns the simulations of the rules that are actually used,
+// including the ones used by continue = true.
+func usedMatches(sims []ruleSimulation) []*ruleSimulation {
+	var r []*ruleSimulation
+	for i := range sims {
+		if sims[i].Mismatch != nil {
+			continue
+		}
+		r = append(r, &sims[i])
+		if !sims[i].Rule.Continue {
+			break
+		}
+	}
+	return r
+}
+
+func printSimulation(in simulateInput, sims []ruleSimulation) {
+	log.Println(caption.Renderln("音声ファイル:"), in.Path)
+	if in.IgnoreDir() {
+		log.Println(suppress.Renderln("  フォルダーが指定されていないため、フォルダーの確認は省略します"))
+	}
+	if in.Text != nil {
+		log.Println(suppress.Renderln("  テキスト:"), *in.Text, suppress.Renderln("("+encodingNames[in.Encoding]+")"))
+	}
+	used := map[*ruleSimulation]bool{}
+	for _, rs := range usedMatches(sims) {
+		used[rs] = true
+	}
+	for i := range sims {
+		rs := &sims[i]
+		switch {
+		case rs.Mismatch != nil:
+			log.Println(suppress.Renderln("ルール", rs.Rule.index, ": 一致しません"))
+			log.Println(suppress.Renderln("  " + rs.Mismatch.Reason))
+			for _, d := range rs.Mismatch.Details {
+				log.Println(suppress.Renderln("    " + d))
+			}
+			continue
+		case used[rs]:
+			log.Println(info.Renderln("ルール", rs.Rule.index, ": 一致しました（このルールが使用されます）"))
+		default:
+			log.Println(info.Renderln("ルール", rs.Rule.index, ": 一致しました（先に一致したルールがあるため使用されません）"))
+		}
+		if rs.Err != nil {
+			log.Println(warn.Renderln("  エラー:"), rs.Err)
+			continue
+		}
+		log.Println(suppress.Renderln("  テキスト:"), rs.Match.Text)
+		if len(rs.Match.Captures) > 0 {
+			names := make([]string, 0, len(rs.Match.Captures))
+			for name := range rs.Match.Captures {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			log.Println(suppress.Renderln("  キャプチャー:"))
+			for _, name := range names {
+				log.Println(suppress.Renderln("    %CAP:"+name+"%:"), rs.Match.Captures[name])
+			}
+		}
+		if rs.Rule.Modifier != "" {
+			log.Println(suppress.Renderln("  modifier による変更:"))
+			printModifierChanges(newModifierVars(rs.Match, in.Path), rs.Vars)
+		}
+		log.Println(suppress.Renderln("  挿入先レイヤー:"), rs.Vars.Layer)
+		if rs.Rule.FileMove == "move" || rs.Rule.FileMove == "copy" {
+			log.Println(suppress.Renderln("  "+rs.Rule.FileMove.Readable()+"先:"), rs.Match.DestDir(in.ProjectPath, time.Now()))
+		}
+	}
+	if len(used) == 0 {
+		log.Println(warn.Renderln("一致するルールが見つかりませんでした"))
+	}
+}
+
+func printModifierChanges(before, after *modifierVars) {
+	changed := false
+	show := func(name string, b, a interface{}) {
+		if fmt.Sprint(b) == fmt.Sprint(a) {
+			return
+		}
+		changed = true
+		log.Println(suppress.Renderln("    "+name+":"), b, suppress.Renderln("->"), a)
+	}
+	show("layer", before.Layer, after.Layer)
+	show("text", before.Text, after.Text)
+	show("filename", before.Filename, after.Filename)
+	show("padding", before.Padding, after.Padding)
+	show("userdata", before.UserData, after.UserData)
+	show("exofile", before.ExoFile, after.ExoFile)
+	show("luafile", before.LuaFile, after.LuaFile)
+	if !changed {
+		log.Println(suppress.Renderln("    変更はありません"))
+	}
+}
+
+// simulateCase is a test case for the rules.
+type simulateCase struct {
+	simulateInput
+	// WantRule is the index of the rule expected to be used. 0 means no rule matches.
+	WantRule     int
+	WantLayer    *int
+	WantText     *string
+	WantFilename *string
+}
+
+func loadSimulateCases(path string) ([]simulateCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, err := loadTOML(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read case file: %w", err)
+	}
+	var r []simulateCase
+	for _, tr := range getSubTreeArray("case", config) {
+		var c simulateCase
+		c.Path = getString("path", tr, "")
+		if c.Path == "" {
+			return nil, fmt.Errorf("case %d: path is required", len(r)+1)
+		}
+		if filepath.Base(c.Path) != c.Path && !filepath.IsAbs(c.Path) {
+			c.Path = filepath.Join(filepath.Dir(path), c.Path)
+		}
+		if tr.Has("text") {
+			s := getString("text", tr, "")
+			c.Text = &s
+		}
+		c.ProjectPath = getString("project", tr, "")
+		c.Encoding = getString("encoding", tr, "sjis")
+		if _, ok := encodingNames[c.Encoding]; !ok {
+			return nil, fmt.Errorf("case %d: unknown encoding %q", len(r)+1, c.Encoding)
+		}
+		c.WantRule = getInt("wantrule", tr, 0)
+		if tr.Has("wantlayer") {
+			i := getInt("wantlayer", tr, 0)
+			c.WantLayer = &i
+		}
+		if tr.Has("wanttext") {
+			s := getString("wanttext", tr, "")
+			c.WantText = &s
+		}
+		if tr.Has("wantfilename") {
+			s := getString("wantfilename", tr, "")
+			c.WantFilename = &s
+		}
+		r = append(r, c)
+	}
+	return r, nil
+}
+
+// verify returns the differences between the case and the simulation.
+func (c *simulateCase) verify(sims []ruleSimulation) []string {
+	rs := firstMatch(sims)
+	if rs == nil {
+		if c.WantRule != 0 {
+			return []string{fmt.Sprintf("ルール: 期待値 %d / 結果 一致なし", c.WantRule)}
+		}
+		return nil
+	}
+	if rs.Rule.index != c.WantRule {
+		return []string{fmt.Sprintf("ルール: 期待値 %d / 結果 %d", c.WantRule, rs.Rule.index)}
+	}
+	if rs.Err != nil {
+		return []string{fmt.Sprintf("エラー: %v", rs.Err)}
+	}
+	var r []string
+	if c.WantLayer != nil && *c.WantLayer != rs.Vars.Layer {
+		r = append(r, fmt.Sprintf("レイヤー: 期待値 %d / 結果 %d", *c.WantLayer, rs.Vars.Layer))
+	}
+	if c.WantText != nil && *c.WantText != rs.Vars.Text {
+		r = append(r, fmt.Sprintf("テキスト: 期待値 %q / 結果 %q", *c.WantText, rs.Vars.Text))
+	}
+	if c.WantFilename != nil && *c.WantFilename != rs.Vars.Filename {
+		r = append(r, fmt.Sprintf("ファイル名: 期待値 %q / 結果 %q", *c.WantFilename, rs.Vars.Filename))
+	}
+	return r
+}
+
+// runSimulateCases runs the cases and returns the number of failed cases.
+func runSimulateCases(ss *setting, cases []simulateCase) int {
+	failed := 0
+	for i := range cases {
+		c := &cases[i]
+		sims, err := simulate(ss, c.simulateInput)
+		var diffs []string
+		if err != nil {
+			diffs = []string{err.Error()}
+		} else {
+			diffs = c.verify(sims)
+		}
+		if len(diffs) == 0 {
+			log.Println(info.Renderln("[OK]"), "No.", i+1, c.Path)
+			continue
+		}
+		failed++
+		log.Println(warn.Renderln("[NG]"), "No.", i+1, c.Path)
+		for _, d := range diffs {
+			log.Println("  " + d)
+		}
+	}
+	if failed == 0 {
+		log.Println(len(cases), "件のケースがすべて成功しました")
+	} else {
+		log.Println(len(cases), "件中", failed, "件のケースが失敗しました")
+	}
+	return failed
+}
+
+// runSimulate implements the simulate subcommand.
+// It returns false if an error occurs or some cases fail.
+func runSimulate(args []string, settingFile string, tempDir string, d dropper) bool {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	settingPath := fs.String("setting", settingFile, "setting file")
+	text := fs.String("text", "", "use this text instead of reading the text file")
+	encoding := fs.String("encoding", "sjis", "encoding of the text specified by -text")
+	casesFile := fs.String("cases", "", "run the test cases in this file")
+	fs.Parse(args)
+	if *casesFile == "" && fs.NArg() != 1 {
+		log.Println("使い方: forcepser.exe simulate [-setting file] [-text text [-encoding enc]] audiofile")
+		log.Println("        forcepser.exe simulate [-setting file] -cases file")
+		return false
+	}
+	if _, ok := encodingNames[*encoding]; !ok {
+		log.Println(warn.Renderln("不明な encoding が指定されました:"), *encoding)
+		return false
+	}
+
+	projectPath := getProjectPath(d)
+	ss, err := loadSetting(*settingPath, tempDir, projectPath)
+	if err != nil {
+		log.Println(warn.Renderln("設定の読み込みに失敗しました:"), err)
+		return false
+	}
+	// prevent execute() in modifier scripts from running.
+	dryRun = true
+
+	if *casesFile != "" {
+		cases, err := loadSimulateCases(*casesFile)
+		if err != nil {
+			log.Println(warn.Renderln("ケースファイルの読み込みに失敗しました:"), err)
+			return false
+		}
+		for i := range cases {
+			if cases[i].ProjectPath == "" {
+				cases[i].ProjectPath = projectPath
+			}
+		}
+		return runSimulateCases(ss, cases) == 0
+	}
+
+	in := simulateInput{Path: fs.Arg(0), Encoding: *encoding, ProjectPath: projectPath}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "text" {
+			in.Text = text
+		}
+	})
+	if !in.IgnoreDir() {
+		if in.Path, err = filepath.Abs(in.Path); err != nil {
+			log.Println(warn.Renderln("filepath.Abs に失敗しました:"), err)
+			return false
+		}
+	}
+	sims, err := simulate(ss, in)
+	if err != nil {
+		log.Println(warn.Renderln("ルールの評価に失敗しました:"), err)
+		return false
+	}
+	printSimulation(in, sims)
+	return true
+}
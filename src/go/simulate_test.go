@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+file = 'voice_*.wav'
+encoding = 'utf8'
+text = '^きりたん＞'
+layer = 1
+modifier = '''
+  text = text:sub(string.len("きりたん＞") + 1)
+  filename = "kiritan.wav"
+'''
+
+[[rule]]
+file = 'voice_*.wav'
+encoding = 'sjis'
+layer = 2
+
+[[rule]]
+file = '*.wav'
+layer = 3
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wavPath := filepath.Join(dir, "voice_1.wav")
+	writeTestWave(t, wavPath, 100)
+	writeTestText(t, changeExt(wavPath, ".txt"), "きりたん＞こんにちは")
+
+	text := "きりたん＞こんばんは"
+	tests := []struct {
+		Input    simulateInput
+		Mismatch []string
+		Layer    int
+		Text     string
+		Filename string
+	}{
+		{
+			Input:    simulateInput{Path: wavPath},
+			Mismatch: []string{"", "", ""},
+			Layer:    1,
+			Text:     "こんにちは",
+			Filename: "kiritan.wav",
+		},
+		{
+			Input:    simulateInput{Path: "voice_2.wav", Text: &text, Encoding: "sjis"},
+			Mismatch: []string{"テキスト内容が正規表現にマッチしませんでした", "", ""},
+			Layer:    2,
+			Text:     text,
+			Filename: "voice_2.wav",
+		},
+		{
+			Input:    simulateInput{Path: filepath.Join(dir, "other.wav")},
+			Mismatch: []string{"ファイル名がワイルドカードに一致しません", "ファイル名がワイルドカードに一致しません", "テキストファイルが見つかりません"},
+		},
+	}
+	for i, test := range tests {
+		sims, err := simulate(s, test.Input)
+		if err != nil {
+			t.Fatalf("No.%d: %v", i, err)
+		}
+		for j, rs := range sims {
+			var got string
+			if rs.Mismatch != nil {
+				got = rs.Mismatch.Reason
+			}
+			if got != test.Mismatch[j] {
+				t.Errorf("No.%d-%d: want %q got %q", i, j, test.Mismatch[j], got)
+			}
+		}
+		rs := firstMatch(sims)
+		if test.Layer == 0 {
+			if rs != nil {
+				t.Errorf("No.%d: want no match got rule %d", i, rs.Rule.index)
+			}
+			continue
+		}
+		if rs == nil || rs.Err != nil {
+			t.Fatalf("No.%d: unexpected result %+v", i, rs)
+		}
+		if rs.Vars.Layer != test.Layer || rs.Vars.Text != test.Text || rs.Vars.Filename != test.Filename {
+			t.Errorf("No.%d: unexpected result %+v", i, rs.Vars)
+		}
+	}
+	if !exists(wavPath) || exists(filepath.Join(dir, "kiritan.wav")) {
+		t.Errorf("files should not be touched")
+	}
+}
+
+func TestSimulateCases(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+[[rule]]
+file = '*_きりたん_*.wav'
+encoding = 'utf8'
+layer = 3
+
+[[rule]]
+encoding = 'utf8'
+layer = 5
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	casesPath := filepath.Join(dir, "cases.txt")
+	err = os.WriteFile(casesPath, []byte(`
+[[case]]
+path = '1_きりたん_こんにちは.wav'
+text = 'こんにちは'
+encoding = 'utf8'
+wantrule = 1
+wantlayer = 3
+
+[[case]]
+path = '2_ずんだもん_こんにちは.wav'
+text = 'こんにちは'
+encoding = 'utf8'
+wantrule = 1
+
+[[case]]
+path = '3_ずんだもん_こんにちは.wav'
+text = 'こんにちは'
+encoding = 'utf8'
+wantrule = 2
+wanttext = 'こんばんは'
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := loadSimulateCases(casesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 3 {
+		t.Fatalf("want 3 cases got %d", len(cases))
+	}
+	if failed := runSimulateCases(s, cases); failed != 2 {
+		t.Errorf("want 2 failures got %d", failed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// standInDropper reads the project information from a JSON file and
+// records every drop into a directory instead of sending it to AviUtl.
+//
+// Each drop is written as NNNNNN.json and the dropped files are copied
+// next to it as NNNNNN_M.ext.
+type standInDropper struct {
+	ProjectFile string
+	OutDir      string
+
+	mu  sync.Mutex
+	seq int
+}
+
+type standInDrop struct {
+	Window       uintptr  `json:"window"`
+	Layer        int      `json:"layer"`
+	FrameAdvance int      `json:"frameadvance"`
+	Files        []string `json:"files"`
+	Copies       []string `json:"copies"`
+}
+
+func newStandInDropper(projectFile string, outDir string) (*standInDropper, error) {
+	if err := os.MkdirAll(outDir, 0777); err != nil {
+		return nil, fmt.Errorf("ドロップ記録用フォルダーの作成に失敗しました: %w", err)
+	}
+	d := &standInDropper{
+		ProjectFile: projectFile,
+		OutDir:      outDir,
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return nil, fmt.Errorf("ドロップ記録用フォルダーの読み取りに失敗しました: %w", err)
+	}
+	for _, e := range entries {
+		n, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err == nil && n > d.seq {
+			d.seq = n
+		}
+	}
+	return d, nil
+}
+
+func (d *standInDropper) GCMZDropsData() (*gcmzDropsData, error) {
+	b, err := os.ReadFile(d.ProjectFile)
+	if err != nil {
+		return nil, err
+	}
+	var proj gcmzDropsData
+	if err = json.Unmarshal(skipUTF8BOM(b), &proj); err != nil {
+		return nil, fmt.Errorf("プロジェクト情報 %s の読み取りに失敗しました: %w", d.ProjectFile, err)
+	}
+	return &proj, nil
+}
+
+func (d *standInDropper) SendFiles(window uintptr, layer int, frameAdv int, files []string) error {
+	_, err := d.record(window, layer, frameAdv, files)
+	return err
+}
+
+// record writes the drop and returns the copied files.
+func (d *standInDropper) record(window uintptr, layer int, frameAdv int, files []string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	name := fmt.Sprintf("%06d", d.seq)
+	drop := standInDrop{
+		Window:       window,
+		Layer:        layer,
+		FrameAdvance: frameAdv,
+		Files:        files,
+	}
+	for i, f := range files {
+		c := fmt.Sprintf("%s_%d%s", name, i+1, filepath.Ext(f))
+		if err := copyFile(filepath.Join(d.OutDir, c), f); err != nil {
+			return nil, err
+		}
+		drop.Copies = append(drop.Copies, c)
+	}
+	b, err := json.MarshalIndent(drop, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(d.OutDir, name+".json"), b, 0666); err != nil {
+		return nil, err
+	}
+	copies := make([]string, 0, len(drop.Copies))
+	for _, c := range drop.Copies {
+		copies = append(copies, filepath.Join(d.OutDir, c))
+	}
+	return copies, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// getTemplates returns the [template.<name>] tables.
+func getTemplates(t *toml.Tree) (map[string]*toml.Tree, error) {
+	r := map[string]*toml.Tree{}
+	v := t.Get("template")
+	if v == nil {
+		return r, nil
+	}
+	tt, ok := v.(*toml.Tree)
+	if !ok {
+		return nil, fmt.Errorf("template must be written as [template.<name>] sections")
+	}
+	for _, name := range tt.Keys() {
+		sub, ok := tt.GetPath([]string{name}).(*toml.Tree)
+		if !ok {
+			return nil, fmt.Errorf("template %q must be a table", name)
+		}
+		r[name] = sub
+	}
+	return r, nil
+}
+
+// templateChain returns the names of templates that tr inherits from, nearest first.
+func templateChain(tr *toml.Tree, templates map[string]*toml.Tree) ([]string, error) {
+	var chain []string
+	visited := map[string]bool{}
+	name := getString("extends", tr, "")
+	for name != "" {
+		if visited[name] {
+			return nil, fmt.Errorf("circular extends detected: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+		t, ok := templates[name]
+		if !ok {
+			return nil, fmt.Errorf("template %q is not found", name)
+		}
+		visited[name] = true
+		chain = append(chain, name)
+		name = getString("extends", t, "")
+	}
+	return chain, nil
+}
+
+// inheritTemplates returns the tree that has the values of tr and the templates it inherits from.
+// The returned map tells which template each inherited value came from.
+func inheritTemplates(tr *toml.Tree, templates map[string]*toml.Tree) (*toml.Tree, []string, map[string]string, error) {
+	chain, err := templateChain(tr, templates)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(chain) == 0 {
+		return tr, nil, map[string]string{}, nil
+	}
+	merged, err := toml.TreeFromMap(map[string]interface{}{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	origins := map[string]string{}
+	set := func(t *toml.Tree, origin string) {
+		for _, key := range t.Keys() {
+			if key == "extends" {
+				continue
+			}
+			path := []string{key}
+			merged.SetPath(path, t.GetPath(path))
+			if origin == "" {
+				delete(origins, key)
+			} else {
+				origins[key] = origin
+			}
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		set(templates[chain[i]], chain[i])
+	}
+	set(tr, "")
+	return merged, chain, origins, nil
+}
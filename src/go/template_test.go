@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSetting(strings.NewReader(`
+filemove = 'copy'
+
+[template.base]
+encoding = 'utf8'
+filemove = 'move'
+layer = 2
+
+[template.kiritan]
+extends = 'base'
+file = '*_きりたん_*.wav'
+layer = 3
+modifier = '''
+  text = "きりたん"
+'''
+
+[[rule]]
+extends = 'kiritan'
+layer = 4
+
+[[rule]]
+extends = 'base'
+
+[[rule]]
+layer = 5
+`), dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Extends  string
+		File     string
+		Encoding string
+		FileMove moveType
+		Layer    int
+		Modifier bool
+		Origins  map[string]string
+	}{
+		{"kiritan -> base", "*_きりたん_*.wav", "utf8", "move", 4, true, map[string]string{
+			"file":     `テンプレート "kiritan"`,
+			"modifier": `テンプレート "kiritan"`,
+			"encoding": `テンプレート "base"`,
+			"filemove": `テンプレート "base"`,
+		}},
+		{"base", "*.wav", "utf8", "move", 2, false, map[string]string{
+			"encoding": `テンプレート "base"`,
+			"filemove": `テンプレート "base"`,
+			"layer":    `テンプレート "base"`,
+		}},
+		{"", "*.wav", "sjis", "copy", 5, false, map[string]string{
+			"filemove": "グローバル設定",
+		}},
+	}
+	for i, test := range tests {
+		r := &s.Rule[i]
+		if got := strings.Join(r.Extends, " -> "); got != test.Extends {
+			t.Errorf("No.%d: extends want %q got %q", i, test.Extends, got)
+		}
+		if r.File != test.File || r.Encoding != test.Encoding || r.FileMove != test.FileMove || r.Layer != test.Layer || (r.Modifier != "") != test.Modifier {
+			t.Errorf("No.%d: unexpected rule %+v", i, r)
+		}
+		if len(r.origins) != len(test.Origins) {
+			t.Errorf("No.%d: origins want %v got %v", i, test.Origins, r.origins)
+			continue
+		}
+		for k, v := range test.Origins {
+			if r.origins[k] != v {
+				t.Errorf("No.%d: origin of %s want %q got %q", i, k, v, r.origins[k])
+			}
+		}
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	tests := []struct {
+		Setting string
+		Err     string
+	}{
+		{`
+[template.a]
+extends = 'b'
+
+[template.b]
+extends = 'a'
+
+[[rule]]
+extends = 'a'
+`, "circular extends detected: a -> b -> a"},
+		{`
+[[rule]]
+extends = 'notfound'
+`, `template "notfound" is not found`},
+		{`
+template = 'a'
+`, "template must be written as [template.<name>] sections"},
+	}
+	for i, test := range tests {
+		_, err := newSetting(strings.NewReader(test.Setting), t.TempDir(), "")
+		if err == nil || !strings.Contains(err.Error(), test.Err) {
+			t.Errorf("No.%d: want %q got %v", i, test.Err, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// expander replaces %NAME% in paths.
+//
+// It knows the built-in variables such as %BASEDIR%, the user-defined variables in [vars]
+// and %ENV:NAME% for environment variables. Unknown variables are left as is.
+type expander struct {
+	vars map[string]string
+	// userVars is the names of the user-defined variables in sorted order.
+	userVars []string
+}
+
+// fileVarNames is the variables that are resolved when each file is processed.
+var fileVarNames = map[string]bool{
+	"PROJECTNAME": true,
+	"DATE":        true,
+	"TIME":        true,
+	"RULEINDEX":   true,
+}
+
+func builtinVars(baseDir string, tempDir string, projectDir string) map[string]string {
+	return map[string]string{
+		"BASEDIR":    baseDir,
+		"TEMPDIR":    tempDir,
+		"PROJECTDIR": projectDir,
+		"PROFILE":    getSpecialFolderPath(CSIDL_PROFILE),
+		"DESKTOP":    getSpecialFolderPath(CSIDL_DESKTOP),
+		"MYDOC":      getSpecialFolderPath(CSIDL_PERSONAL),
+	}
+}
+
+// newExpander creates expander from the built-in variables and the [vars] table.
+// The user-defined variables can refer to the other variables.
+func newExpander(builtins map[string]string, t *toml.Tree) (*expander, error) {
+	e := &expander{vars: map[string]string{}}
+	for k, v := range builtins {
+		e.vars[k] = v
+	}
+	if t == nil {
+		return e, nil
+	}
+	raw := map[string]string{}
+	for _, name := range t.Keys() {
+		if _, ok := builtins[name]; ok || fileVarNames[name] {
+			return nil, fmt.Errorf("vars: %%%s%% is a built-in variable", name)
+		}
+		if name == "" || strings.ContainsAny(name, "%:{}") {
+			return nil, fmt.Errorf("vars: invalid variable name %q", name)
+		}
+		raw[name] = toString(t.GetPath([]string{name}))
+		e.userVars = append(e.userVars, name)
+	}
+	sort.Strings(e.userVars)
+
+	resolving := map[string]bool{}
+	var stack []string
+	var resolve func(name string) error
+	resolve = func(name string) error {
+		if _, ok := e.vars[name]; ok {
+			return nil
+		}
+		if resolving[name] {
+			return fmt.Errorf("vars: circular reference detected: %s -> %s", strings.Join(stack, " -> "), name)
+		}
+		resolving[name] = true
+		stack = append(stack, name)
+		var err error
+		v := e.expand(raw[name], func(ref string) (string, bool) {
+			if _, ok := raw[ref]; ok && err == nil {
+				err = resolve(ref)
+			}
+			return e.lookup(ref)
+		})
+		if err != nil {
+			return err
+		}
+		stack = stack[:len(stack)-1]
+		e.vars[name] = v
+		return nil
+	}
+	for _, name := range e.userVars {
+		if err := resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *expander) lookup(name string) (string, bool) {
+	if strings.HasPrefix(name, "ENV:") {
+		return os.LookupEnv(name[4:])
+	}
+	v, ok := e.vars[name]
+	return v, ok
+}
+
+// Replace expands the variables in s.
+func (e *expander) Replace(s string) string {
+	return e.expand(s, e.lookup)
+}
+
+func (e *expander) expand(s string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i == -1 {
+			b.WriteString(s)
+			break
+		}
+		j := strings.IndexByte(s[i+1:], '%')
+		if k := strings.IndexByte(s[i+1:], '{'); k > 0 && (j == -1 || k < j) && isVarName(s[i+1:i+1+k]) {
+			// %DATE{%Y%m%d}% contains % in the braces.
+			if l := strings.Index(s[i+1+k:], "}%"); l != -1 {
+				j = k + l + 1
+			}
+		}
+		if j == -1 {
+			b.WriteString(s)
+			break
+		}
+		j += i + 1
+		if v, ok := lookup(s[i+1 : j]); ok {
+			b.WriteString(s[:i])
+			b.WriteString(v)
+			s = s[j+1:]
+			continue
+		}
+		// the closing % may be the beginning of the next variable.
+		b.WriteString(s[:j])
+		s = s[j:]
+	}
+	return b.String()
+}
+
+// UserVars returns the names of the user-defined variables in sorted order.
+func (e *expander) UserVars() []string {
+	return e.userVars
+}
+
+func isVarName(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ReplaceWith expands the variables in s. lookup is tried before the other variables.
+func (e *expander) ReplaceWith(s string, lookup func(name string) (string, bool)) string {
+	return e.expand(s, func(name string) (string, bool) {
+		if v, ok := lookup(name); ok {
+			return v, true
+		}
+		return e.lookup(name)
+	})
+}
+
+// fileVars returns the lookup function for the variables that are resolved when each file is processed.
+//
+//	%PROJECTNAME%   the project filename without extension
+//	%DATE%, %TIME%  the current date and time. %DATE{%Y%m%d}% specifies the format
+//	%RULEINDEX%     the index of the matched rule
+//	%CAP:name%      the named capture group of filere, sanitized by sanitizePathToken
+func fileVars(projectPath string, now time.Time, ruleIndex int, captures map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch {
+		case name == "PROJECTNAME":
+			if projectPath == "" {
+				return "", false
+			}
+			base := filepath.Base(projectPath)
+			return strings.TrimSuffix(base, filepath.Ext(base)), true
+		case name == "DATE":
+			return strftime(now, "%Y-%m-%d"), true
+		case name == "TIME":
+			return strftime(now, "%H-%M-%S"), true
+		case (strings.HasPrefix(name, "DATE{") || strings.HasPrefix(name, "TIME{")) && strings.HasSuffix(name, "}"):
+			return strftime(now, name[5:len(name)-1]), true
+		case name == "RULEINDEX":
+			return strconv.Itoa(ruleIndex), true
+		case strings.HasPrefix(name, "CAP:"):
+			v, ok := captures[name[4:]]
+			return sanitizePathToken(v), ok
+		}
+		return "", false
+	}
+}
+
+// sanitizePathToken removes the characters that tofilename removes and the path separators from s,
+// and trims the leading and trailing dots so that the text captured from the file cannot
+// point outside the folder such as "..\..\".
+func sanitizePathToken(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if isInvalidFilenameChar(c) || c == '\\' {
+			return -1
+		}
+		return c
+	}, s)
+	return strings.Trim(s, ".")
+}
+
+var capVarRE = regexp.MustCompile(`%CAP:([^%]+)%`)
+
+// capNames returns the capture group names used as %CAP:name% in s.
+func capNames(s string) []string {
+	var r []string
+	for _, m := range capVarRE.FindAllStringSubmatch(s, -1) {
+		r = append(r, m[1])
+	}
+	return r
+}
+
+// strftime formats t like strftime in C.
+// It supports %Y %y %m %d %H %M %S %j and %%. The other characters are copied as is.
+func strftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpander(t *testing.T) {
+	t.Setenv("FORCEPSER_TEST", "env")
+	s, err := newSetting(strings.NewReader(`
+basedir = 'base'
+
+[vars]
+ROOT = '%BASEDIR%\voice'
+KIRITAN = '%ROOT%\kiritan'
+OUT = '%ENV:FORCEPSER_TEST%\out'
+
+[[rule]]
+dir = '%KIRITAN%'
+destdir = '%OUT%\%PROJECTDIR%'
+exofile = '%ROOT%\template.exo'
+
+[[asas]]
+exe = 'VoiceroidEditor.exe'
+folder = '%KIRITAN%'
+`), "temp", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Input string
+		Want  string
+	}{
+		{s.Rule[0].ExpandedDir(), `base\voice\kiritan`},
+		{s.Rule[0].ExpandedDestDir(), `env\out\project`},
+		{s.Asas[0].ExpandedFolder(), `base\voice\kiritan`},
+		{s.dirReplacer.Replace(s.Rule[0].ExoFile), `base\voice\template.exo`},
+		{s.dirReplacer.Replace("%UNKNOWN%%TEMPDIR%"), "%UNKNOWN%temp"},
+		{s.dirReplacer.Replace("100%%ROOT%"), `100%base\voice`},
+		{s.dirReplacer.Replace("%ENV:FORCEPSER_NOT_FOUND%"), "%ENV:FORCEPSER_NOT_FOUND%"},
+		{s.dirReplacer.Replace("50%"), "50%"},
+	}
+	for i, test := range tests {
+		if test.Input != test.Want {
+			t.Errorf("No.%d: want %q got %q", i, test.Want, test.Input)
+		}
+	}
+	if got := strings.Join(s.dirReplacer.UserVars(), ","); got != "KIRITAN,OUT,ROOT" {
+		t.Errorf("unexpected user vars %s", got)
+	}
+}
+
+func TestExpanderError(t *testing.T) {
+	tests := []struct {
+		Setting string
+		Err     string
+	}{
+		{"[vars]\nA = '%B%'\nB = '%C%'\nC = '%A%'", "circular reference detected: A -> B -> C -> A"},
+		{"[vars]\nTEMPDIR = 'x'", "%TEMPDIR% is a built-in variable"},
+		{"vars = 'x'", "vars must be written as [vars] section"},
+		{"[vars]\nDATE = 'x'", "%DATE% is a built-in variable"},
+		{"[[rule]]\nfilere = '(?P<name>.+)'\ndestdir = '%CAP:char%'", `capture group "char" used in destdir is not found in filere or text`},
+	}
+	for i, test := range tests {
+		_, err := newSetting(strings.NewReader(test.Setting), filepath.Join("temp"), "")
+		if err == nil || !strings.Contains(err.Error(), test.Err) {
+			t.Errorf("No.%d: want %q got %v", i, test.Err, err)
+		}
+	}
+}
+
+func TestFileVars(t *testing.T) {
+	s, err := newSetting(strings.NewReader(`
+[vars]
+VOICE = '%PROJECTDIR%\voice'
+
+[[rule]]
+filere = '^(?P<char>[^_]+)_(?P<text>.+)\.wav$'
+destdir = '%VOICE%\%PROJECTNAME%\%CAP:char%\%DATE%_%TIME%'
+
+[[rule]]
+destdir = '%DATE{%Y%m%d}%\%TIME{%H%M}%\%RULEINDEX%\%PROJECTNAME%'
+`), "temp", "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 5, 7, 8, 9, 0, time.Local)
+	tests := []struct {
+		Match       match
+		ProjectPath string
+		Want        string
+	}{
+		{
+			Match:       match{Rule: &s.Rule[0], Captures: map[string]string{"char": "きりたん", "text": "こんにちは"}},
+			ProjectPath: filepath.Join("project", "movie.aup"),
+			Want:        `project\voice\movie\きりたん\2024-03-05_07-08-09`,
+		},
+		{
+			Match:       match{Rule: &s.Rule[0], Captures: map[string]string{"char": `..\..\Windows`}},
+			ProjectPath: filepath.Join("project", "movie.aup"),
+			Want:        `project\voice\movie\Windows\2024-03-05_07-08-09`,
+		},
+		{
+			Match:       match{Rule: &s.Rule[0], Captures: map[string]string{"char": "C:/a/../b."}},
+			ProjectPath: filepath.Join("project", "movie.aup"),
+			Want:        `project\voice\movie\Ca..b\2024-03-05_07-08-09`,
+		},
+		{
+			Match:       match{Rule: &s.Rule[1], Captures: map[string]string{}},
+			ProjectPath: "",
+			Want:        `20240305\0708\2\%PROJECTNAME%`,
+		},
+	}
+	for i, test := range tests {
+		if got := test.Match.DestDir(test.ProjectPath, now); got != test.Want {
+			t.Errorf("No.%d: want %q got %q", i, test.Want, got)
+		}
+	}
+
+	strftimeTests := []struct {
+		Format string
+		Want   string
+	}{
+		{"%Y/%y/%m/%d %H:%M:%S", "2024/24/03/05 07:08:09"},
+		{"%j %% %Q 100%", "065 % %Q 100%"},
+	}
+	for i, test := range strftimeTests {
+		if got := strftime(now, test.Format); got != test.Want {
+			t.Errorf("strftime No.%d: want %q got %q", i, test.Want, got)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 local function finddrop(file, hash, proj, success)
-  local rule, text, outfile = findrule(file)
-  if rule == nil then
+  local matches = findrules(file)
+  if matches == nil then
     debug_error("  一致するルールが見つかりませんでした")
     table.insert(success, {src=file, hash=hash})
     return
   end
-  debug_print_verbose("ルールに一致: " .. rule.file .. " / 挿入先レイヤー: " .. rule.layer)
-  drop(proj, outfile, text, rule)
-  table.insert(success, {src=file, hash=hash, dest=outfile})
-  debug_print("  レイヤー " .. rule.layer .. " へドロップしました")
+  -- continue = true のルールがあると、一致したルールごとにドロップする
+  -- 途中で失敗しても、ドロップ済みのものを再送しないように最初のドロップの時点で記録する
+  local entry = nil
+  for _, m in ipairs(matches) do
+    debug_print_verbose("ルールに一致: " .. m.rule.file .. " / 挿入先レイヤー: " .. m.rule.layer)
+    drop(proj, m.path, m.text, m.rule)
+    if entry == nil then
+      entry = {src=file, hash=hash, dests={}, rules={}}
+      table.insert(success, entry)
+    end
+    table.insert(entry.dests, m.path)
+    table.insert(entry.rules, m.rule.index)
+    debug_print("  レイヤー " .. m.rule.layer .. " へドロップしました")
+  end
 end
 
 function sortmoddate(a, b)
@@ -17,10 +27,50 @@ end
 function sortname(a, b)
   return a.path < b.path
 end
+function sortnatural(a, b)
+  return naturalless(a.path, b.path)
+end
+function sortctime(a, b)
+  if a.ctime ~= b.ctime then
+    return a.ctime < b.ctime
+  end
+  return a.path < b.path
+end
+function sortsortkey(a, b)
+  if a.sortkey ~= b.sortkey then
+    return naturalless(a.sortkey, b.sortkey)
+  end
+  return naturalless(a.path, b.path)
+end
+
+local sorters = {
+  moddate = sortmoddate,
+  name = sortname,
+  natural = sortnatural,
+  ctime = sortctime,
+  sortkey = sortsortkey,
+}
+
+-- sort = 'lua' のときは sortfunc に書かれたスクリプトを比較関数として使う
+local function getsorter(sort, sortfunc)
+  if sort == "lua" and sortfunc ~= nil then
+    local f, err = loadstring(sortfunc, "sortfunc")
+    if f == nil then
+      error("sortfunc の読み込みに失敗しました: " .. err)
+    end
+    return function(a, b)
+      return f(a, b) and true or false
+    end
+  end
+  return sorters[sort] or sortmoddate
+end
 
 -- ファイルに変更があったときに呼ばれる関数
-function changed(files, sort, proj)
-  table.sort(files, sort == "moddate" and sortmoddate or sortname)
+function changed(files, sort, proj, sortfunc)
+  local ok, err = pcall(table.sort, files, getsorter(sort, sortfunc))
+  if not ok then
+    debug_error("並べ替え中にエラーが発生しました: " .. err)
+  end
   local success = {}
   for _, file in ipairs(files) do
     if file.trycount == 0 then